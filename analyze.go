@@ -0,0 +1,144 @@
+package kvs
+
+import (
+	"encoding/binary"
+	"math/rand"
+)
+
+/*
+	ANALYZE reports the bucket occupancy and placement of a *KEON or *KEVA
+	so a table that is about to start failing inserts can be recognized
+	before it does
+
+	a := kn.Analyze()
+	a.Fill[i]     rows with i of width slots used
+	a.Row[i]      keys placed in their first, second, or third candidate row
+	a.Misplaced   keys found in none of their candidate rows
+	a.FullRun     longest run of consecutive full rows
+	a.ProbeHit    mean slots compared by the lookup of a present key
+	a.ProbeMiss   slots compared by the lookup of an absent key
+	a.Headroom    estimated inserts the table absorbs before a NoSpace
+
+	the Headroom is measured by inserting up to headroomTrials random
+	hashes into a copy of the table with the same Shuffler and Tracker
+	settings; a NoSpace within the trials is the exact headroom and a
+	table that absorbs every trial is reported with the Cap - Len free
+	capacity, so the Analyze time is bounded while the copy doubles the
+	memory of the table for the duration of the Analyze
+
+*/
+
+// analysis is the Analyze bucket occupancy and load report
+type analysis = struct {
+	Count, Max, Slots uint64    // keys, capacity, and slots
+	Depth, Width      uint64    // rows and slots per row
+	Fill              []uint64  // rows by slots used; 0..width
+	Row               [3]uint64 // keys by candidate row
+	Misplaced         uint64    // keys in no candidate row
+	FullRun           uint64    // longest run of full rows
+	ProbeHit          float64   // mean slots compared per hit
+	ProbeMiss         float64   // slots compared per miss
+	Headroom          uint64    // estimated inserts before a NoSpace
+}
+
+// Headroom trial inserts
+const (
+	analyzeSeed    = 0x6b7673 // makes the trial inserts repeatable
+	headroomTrials = 1 << 16  // trial inserts before the free capacity is assumed
+)
+
+// Analyze the *KEON bucket occupancy and estimate the insert headroom.
+func (kn *KEON) Analyze() analysis {
+	var a = analyze(kn.key, kn.depth, kn.width, kn.count, kn.max, kn.calculate)
+	var trial = &KEON{hloc: kn.hloc, count: kn.count, max: kn.max, depth: kn.depth, width: kn.width,
+		density: kn.density, shuffler: kn.shuffler, tracker: kn.tracker, key: append([]uint64(nil), kn.key...)}
+	a.Headroom = headroom(trial.RawInsert(false), kn.max-kn.count)
+	return a
+}
+
+// Analyze the *KEVA bucket occupancy and estimate the insert headroom.
+func (kn *KEVA) Analyze() analysis {
+	var a = analyze(kn.key, kn.depth, kn.width, kn.count, kn.max, kn.calculate)
+	var trial = &KEVA{hloc: kn.hloc, count: kn.count, max: kn.max, depth: kn.depth, width: kn.width,
+		density: kn.density, shuffler: kn.shuffler, tracker: kn.tracker, key: append([]uint64(nil), kn.key...),
+		value: make([]uint64, len(kn.value))}
+	var insert = trial.RawInsert(false)
+	a.Headroom = headroom(func(b []byte) struct{ Ok, Exist, NoSpace bool } { return insert(b, 0) }, kn.max-kn.count)
+	return a
+}
+
+// analyze the occupancy of the key slots shared by *KEON and *KEVA
+func analyze(key []uint64, depth, width, count, max uint64, calculate func(*[4]uint64)) (a analysis) {
+
+	a.Count, a.Max, a.Slots, a.Depth, a.Width = count, max, depth*width, depth, width
+	a.Fill = make([]uint64, width+1)
+	a.ProbeMiss = float64(3 * width) // a lookup compares every candidate slot
+
+	var run, probes uint64
+	var idx [4]uint64
+	for row := uint64(0); row < depth; row++ {
+		var used uint64
+		for j := uint64(0); j < width; j++ {
+			n := row*width + j
+			if key[n] == 0 {
+				continue
+			}
+			used++
+
+			// the candidate row holding the key and the slots a lookup
+			// compares before the match
+			idx[3] = key[n]
+			calculate(&idx)
+			var found bool
+			for i := uint64(0); i < 3 && !found; i++ {
+				if idx[i] == row*width {
+					a.Row[i]++
+					probes += i*width + j + 1
+					found = true
+				}
+			}
+			if !found {
+				a.Misplaced++
+			}
+		}
+		a.Fill[used]++
+
+		if used == width {
+			run++
+			if run > a.FullRun {
+				a.FullRun = run
+			}
+		} else {
+			run = 0
+		}
+	}
+
+	if placed := a.Row[0] + a.Row[1] + a.Row[2]; placed > 0 {
+		a.ProbeHit = float64(probes) / float64(placed)
+	}
+	return
+}
+
+// headroom counts the inserts of random hashes until the first NoSpace, or
+// is the free capacity when headroomTrials inserts do not fill the table
+func headroom(insert func([]byte) struct{ Ok, Exist, NoSpace bool }, free uint64) (n uint64) {
+	var b [8]byte
+	var r = rand.New(rand.NewSource(analyzeSeed))
+	for {
+		if n == headroomTrials {
+			return free
+		}
+		hash := r.Uint64()
+		if hash == 0 {
+			continue
+		}
+		binary.BigEndian.PutUint64(b[:], hash)
+		item := insert(b[:])
+		switch {
+		case item.NoSpace:
+			return
+		case item.Ok:
+			n++
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package kvs
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+	CHUNK splits the slot array of a table into chunks that are processed
+	by parallel goroutines, so that a table file is read and written with
+	ReadAt and WriteAt at disk speed and the checksum of a large table is
+	computed on every core; each goroutine holds one chunk buffer
+
+	slot      offset 80 + slot * 8 for a keon and 80 + slot * 16 for a keva
+	chunk     chunkSlots slots; the last chunk holds the remainder
+
+*/
+
+// chunkSlots is the slots of a chunk; 2MB of keon file
+const chunkSlots = 1 << 18
+
+// chunkCount is the number of chunks of n slots
+func chunkCount(n uint64) int { return int((n + chunkSlots - 1) / chunkSlots) }
+
+// chunks calls fn with each chunk i of slots [from,to) of n slots and a
+// buffer of size bytes per slot on up to GOMAXPROCS goroutines; the first
+// error stops the chunks that have not started and is returned
+func chunks(n uint64, size int, fn func(i int, from, to uint64, buf []byte) error) error {
+
+	var count = chunkCount(n)
+	var workers = runtime.GOMAXPROCS(0)
+	if workers > count {
+		workers = count
+	}
+
+	var next atomic.Int64
+	var failed atomic.Bool
+	var mu sync.Mutex
+	var first error
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf = make([]byte, chunkSlots*size)
+			for !failed.Load() {
+				i := int(next.Add(1) - 1)
+				if i >= count {
+					return
+				}
+				from, to := uint64(i)*chunkSlots, uint64(i+1)*chunkSlots
+				if to > n {
+					to = n
+				}
+				if err := fn(i, from, to, buf[:(to-from)*uint64(size)]); err != nil {
+					mu.Lock()
+					if first == nil {
+						first = err
+					}
+					mu.Unlock()
+					failed.Store(true)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return first
+}
+
+// checksum is the XOR of the keys; a large table is summed in parallel chunks
+func checksum(key []uint64) (sum uint64) {
+
+	if len(key) < 4*chunkSlots {
+		for i := range key {
+			sum ^= key[i]
+		}
+		return
+	}
+
+	var sums = make([]uint64, chunkCount(uint64(len(key))))
+	chunks(uint64(len(key)), 0, func(i int, from, to uint64, _ []byte) error {
+		var s uint64
+		for _, k := range key[from:to] {
+			s ^= k
+		}
+		sums[i] = s
+		return nil
+	})
+	for _, s := range sums {
+		sum ^= s
+	}
+	return
+}
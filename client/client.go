@@ -0,0 +1,390 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	CLIENT is a connection to kvs serve -bin that exposes remote tables
+	with the same Lookup, Insert, and Remove closure shapes as the in
+	process *kvs.KEON and *kvs.KEVA so code can switch between a local
+	and a remote table; a client is safe for concurrent use and calls
+	from many goroutines are pipelined on the one connection
+
+	c, err := client.Dial("localhost:7379") // or unix:{path}
+	defer c.Close()
+	keon, err := c.KEON("block")
+	lookup := keon.Lookup()
+	if lookup(key) { ... }
+	...
+	if err := c.Err(); err != nil { ... } // closure transport failure
+
+	a closure returns the zero result on a transport or server failure
+	and the first failure is reported by Err; a batch reports the error
+
+*/
+
+// ErrClosed is returned after the connection has failed or been closed
+var ErrClosed = errors.New("client: connection closed")
+
+// Client is a pipelined connection to a kvs serve -bin listener
+type Client struct {
+	conn    net.Conn
+	w       *bufio.Writer
+	wmu     sync.Mutex // serialize requests and the pending order
+	pending chan *call // calls awaiting a response in request order
+	closed  bool       // pending is closed
+	emu     sync.Mutex // err and broken
+	err     error      // first closure failure
+	broken  error      // connection failure
+}
+
+// call is a request awaiting its response
+type call struct {
+	resp Response
+	err  error
+	done chan struct{}
+}
+
+// Dial connects to a tcp address or a unix socket given as unix:{path}
+func Dial(addr string) (*Client, error) {
+
+	var network = "tcp"
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", path
+	}
+	conn, err := net.DialTimeout(network, addr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	var c = &Client{conn: conn, w: bufio.NewWriter(conn), pending: make(chan *call, 1024)}
+	go c.read()
+	return c, nil
+}
+
+// read delivers the responses to the pending calls in request order
+func (c *Client) read() {
+
+	var r = bufio.NewReader(c.conn)
+	var buf []byte
+	var err error
+	for call := range c.pending {
+		if err == nil {
+			if err = ReadResponse(r, &call.resp, &buf); err != nil {
+				c.emu.Lock()
+				if c.broken == nil {
+					c.broken = err
+				}
+				c.emu.Unlock()
+				c.conn.Close()
+			}
+		}
+		call.err = err
+		close(call.done)
+	}
+}
+
+// Close the connection; calls in flight fail with ErrClosed
+func (c *Client) Close() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.emu.Lock()
+	if c.broken == nil {
+		c.broken = ErrClosed
+	}
+	c.emu.Unlock()
+	c.closed = true
+	close(c.pending)
+	return c.conn.Close()
+}
+
+// Err is the first transport or server failure of a closure call
+func (c *Client) Err() error {
+	c.emu.Lock()
+	defer c.emu.Unlock()
+	return c.err
+}
+
+// fail records the first closure failure
+func (c *Client) fail(err error) {
+	c.emu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.emu.Unlock()
+}
+
+// Do sends the request and waits for its response; concurrent calls are
+// pipelined and a server error is returned as an error
+func (c *Client) Do(req *Request) ([]Item, error) {
+
+	var call = &call{done: make(chan struct{})}
+	c.wmu.Lock()
+	c.emu.Lock()
+	err := c.broken
+	c.emu.Unlock()
+	if err != nil {
+		c.wmu.Unlock()
+		if err != ErrClosed {
+			err = fmt.Errorf("%w: %v", ErrClosed, err)
+		}
+		return nil, err
+	}
+	if err = WriteRequest(c.w, req); err == nil {
+		if err = c.w.Flush(); err != nil {
+			c.emu.Lock()
+			c.broken = err
+			c.emu.Unlock()
+			c.conn.Close()
+		}
+	}
+	if err != nil {
+		c.wmu.Unlock()
+		return nil, err
+	}
+	c.pending <- call
+	c.wmu.Unlock()
+
+	<-call.done
+	if call.err != nil {
+		return nil, call.err
+	}
+	if len(call.resp.Err) > 0 {
+		return nil, errors.New("client: " + call.resp.Err)
+	}
+	if len(call.resp.Items) != len(req.Keys) && req.Op != OpLen {
+		return nil, ErrFrame
+	}
+	return call.resp.Items, nil
+}
+
+// one sends a single key request for a closure and records a failure
+func (c *Client) one(op, table uint8, key []byte, value uint64) (item Item) {
+	items, err := c.Do(&Request{Op: op, Table: table, Keys: [][]byte{key}, Values: []uint64{value}})
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	return items[0]
+}
+
+// table resolves the name to its table id and checks the signature
+func (c *Client) table(name string, signature uint64) (uint8, error) {
+	items, err := c.Do(&Request{Op: OpTable, Keys: [][]byte{[]byte(name)}})
+	switch {
+	case err != nil:
+		return 0, err
+	case !items[0].Ok:
+		return 0, errors.New("client: unknown table " + name)
+	case items[0].Value&0xffffffff != signature:
+		return 0, errors.New("client: table type mismatch " + name)
+	}
+	return uint8(items[0].Value >> 32), nil
+}
+
+// length is the live table count
+func (c *Client) length(table uint8) uint64 {
+	items, err := c.Do(&Request{Op: OpLen, Table: table})
+	if err != nil || len(items) != 1 {
+		if err == nil {
+			err = ErrFrame
+		}
+		c.fail(err)
+		return 0
+	}
+	return items[0].Value
+}
+
+/*
+	KEON remote set table
+		Lookup, RawLookup, Insert, RawInsert, Remove, RawRemove, Len, LookupBatch
+
+*/
+
+// KEON is a remote *kvs.KEON table
+type KEON struct {
+	c  *Client
+	id uint8
+}
+
+// KEON opens the named remote keon table
+func (c *Client) KEON(name string) (*KEON, error) {
+	id, err := c.table(name, 0xff01)
+	if err != nil {
+		return nil, err
+	}
+	return &KEON{c: c, id: id}, nil
+}
+
+// Lookup key in the remote *KEON.
+func (kn *KEON) Lookup() func(key []byte) bool {
+	return func(key []byte) bool { return kn.c.one(OpLookup, kn.id, key, 0).Ok }
+}
+func (kn *KEON) RawLookup() func(key []byte) bool {
+	return func(key []byte) bool { return kn.c.one(OpLookup|FlagRaw, kn.id, key, 0).Ok }
+}
+
+// Insert into the remote *KEON; requires kvs serve -admin.
+func (kn *KEON) Insert(update bool) func([]byte) struct{ Ok, Exist, NoSpace bool } {
+	return kn.insert(OpInsert, update)
+}
+func (kn *KEON) RawInsert(update bool) func([]byte) struct{ Ok, Exist, NoSpace bool } {
+	return kn.insert(OpInsert|FlagRaw, update)
+}
+
+func (kn *KEON) insert(op uint8, update bool) func([]byte) struct{ Ok, Exist, NoSpace bool } {
+	if update {
+		op |= FlagUpdate
+	}
+	return func(key []byte) (item struct{ Ok, Exist, NoSpace bool }) {
+		r := kn.c.one(op, kn.id, key, 0)
+		item.Ok, item.Exist, item.NoSpace = r.Ok, r.Exist, r.NoSpace
+		return
+	}
+}
+
+// Remove key from the remote *KEON; requires kvs serve -admin.
+func (kn *KEON) Remove() func([]byte) struct{ Ok, Exist bool } { return kn.remove(OpRemove) }
+func (kn *KEON) RawRemove() func([]byte) struct{ Ok, Exist bool } {
+	return kn.remove(OpRemove | FlagRaw)
+}
+
+func (kn *KEON) remove(op uint8) func([]byte) struct{ Ok, Exist bool } {
+	return func(key []byte) (item struct{ Ok, Exist bool }) {
+		r := kn.c.one(op, kn.id, key, 0)
+		item.Ok, item.Exist = r.Ok, r.Exist
+		return
+	}
+}
+
+// Len is the remote table count.
+func (kn *KEON) Len() uint64 { return kn.c.length(kn.id) }
+
+// LookupBatch looks up the keys in one request.
+func (kn *KEON) LookupBatch(keys [][]byte) ([]bool, error) {
+	items, err := kn.c.Do(&Request{Op: OpLookup, Table: kn.id, Keys: keys})
+	if err != nil {
+		return nil, err
+	}
+	var found = make([]bool, len(items))
+	for i := range items {
+		found[i] = items[i].Ok
+	}
+	return found, nil
+}
+
+/*
+	KEVA remote key:value table
+		Lookup, RawLookup, Insert, RawInsert, Remove, RawRemove, Len, LookupBatch
+
+*/
+
+// KEVA is a remote *kvs.KEVA table
+type KEVA struct {
+	c  *Client
+	id uint8
+}
+
+// KEVA opens the named remote keva table
+func (c *Client) KEVA(name string) (*KEVA, error) {
+	id, err := c.table(name, 0xff02)
+	if err != nil {
+		return nil, err
+	}
+	return &KEVA{c: c, id: id}, nil
+}
+
+// Lookup key in the remote *KEVA.
+func (kn *KEVA) Lookup() func(key []byte) (item struct {
+	Value uint64
+	Ok    bool
+}) {
+	return kn.lookup(OpLookup)
+}
+func (kn *KEVA) RawLookup() func(key []byte) (item struct {
+	Value uint64
+	Ok    bool
+}) {
+	return kn.lookup(OpLookup | FlagRaw)
+}
+
+func (kn *KEVA) lookup(op uint8) func(key []byte) (item struct {
+	Value uint64
+	Ok    bool
+}) {
+	return func(key []byte) (item struct {
+		Value uint64
+		Ok    bool
+	}) {
+		r := kn.c.one(op, kn.id, key, 0)
+		item.Value, item.Ok = r.Value, r.Ok
+		return
+	}
+}
+
+// Insert into the remote *KEVA; requires kvs serve -admin. Unlike an
+// in-process *KEVA, an update sets the value of an existing key as the
+// kvs serve http and redis updates do.
+func (kn *KEVA) Insert(update bool) func([]byte, uint64) struct{ Ok, Exist, NoSpace bool } {
+	return kn.insert(OpInsert, update)
+}
+func (kn *KEVA) RawInsert(update bool) func([]byte, uint64) struct{ Ok, Exist, NoSpace bool } {
+	return kn.insert(OpInsert|FlagRaw, update)
+}
+
+func (kn *KEVA) insert(op uint8, update bool) func([]byte, uint64) struct{ Ok, Exist, NoSpace bool } {
+	if update {
+		op |= FlagUpdate
+	}
+	return func(key []byte, value uint64) (item struct{ Ok, Exist, NoSpace bool }) {
+		r := kn.c.one(op, kn.id, key, value)
+		item.Ok, item.Exist, item.NoSpace = r.Ok, r.Exist, r.NoSpace
+		return
+	}
+}
+
+// Remove key from the remote *KEVA; requires kvs serve -admin.
+func (kn *KEVA) Remove() func([]byte) struct{ Ok, Exist bool } { return kn.remove(OpRemove) }
+func (kn *KEVA) RawRemove() func([]byte) struct{ Ok, Exist bool } {
+	return kn.remove(OpRemove | FlagRaw)
+}
+
+func (kn *KEVA) remove(op uint8) func([]byte) struct{ Ok, Exist bool } {
+	return func(key []byte) (item struct{ Ok, Exist bool }) {
+		r := kn.c.one(op, kn.id, key, 0)
+		item.Ok, item.Exist = r.Ok, r.Exist
+		return
+	}
+}
+
+// Len is the remote table count.
+func (kn *KEVA) Len() uint64 { return kn.c.length(kn.id) }
+
+// LookupBatch looks up the keys in one request.
+func (kn *KEVA) LookupBatch(keys [][]byte) ([]struct {
+	Value uint64
+	Ok    bool
+}, error) {
+	items, err := kn.c.Do(&Request{Op: OpLookup, Table: kn.id, Keys: keys})
+	if err != nil {
+		return nil, err
+	}
+	var found = make([]struct {
+		Value uint64
+		Ok    bool
+	}, len(items))
+	for i := range items {
+		found[i].Value, found[i].Ok = items[i].Value, items[i].Ok
+	}
+	return found, nil
+}
@@ -0,0 +1,265 @@
+package client
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+/*
+	PROTOCOL is the length prefixed binary protocol of kvs serve -bin;
+	integers are big endian and responses are returned in request order
+	so requests may be pipelined on a connection, and a request carries
+	a batch of keys
+
+	request   size uint32 | op uint8 | table uint8 | count uint16 | item ...
+	item      size uint16 | key [| value uint64 for OpInsert]
+
+	response  size uint32 | status uint8 | count uint16 | item ...
+	item      flags uint8 | value uint64
+	error     size uint32 | status uint8 | message
+
+	OpTable   resolve the table name in the first key to its id in the
+	          upper 32 bits of the value and the signature in the lower
+	OpLookup  found flag and the keva value of each key
+	OpInsert  insert each key and value; ok, exist, and nospace flags
+	OpRemove  remove each key; ok and exist flags
+	OpLen     live table count as the value of a single item
+
+	FlagRaw marks the keys as raw 8 byte hashes and FlagUpdate marks an
+	insert as updateable, which sets the value of an existing keva key;
+	both are or'd with the op
+
+*/
+
+// protocol operations and op flags
+const (
+	OpTable  uint8 = 0x01
+	OpLookup uint8 = 0x02
+	OpInsert uint8 = 0x03
+	OpRemove uint8 = 0x04
+	OpLen    uint8 = 0x05
+
+	FlagRaw    uint8 = 0x40
+	FlagUpdate uint8 = 0x80
+)
+
+// response item flags
+const (
+	itemOk      uint8 = 0x01
+	itemExist   uint8 = 0x02
+	itemNoSpace uint8 = 0x04
+)
+
+// MaxFrame limits the size of a request or response
+const MaxFrame = 64 << 20
+
+// MaxBatch limits the keys of a request
+const MaxBatch = 1<<16 - 1
+
+var (
+	ErrFrame = errors.New("client: malformed frame")
+	ErrBatch = errors.New("client: batch too large")
+)
+
+// Request is a protocol request of a batch of keys; Values are the
+// insert values and are ignored for other operations
+type Request struct {
+	Op, Table uint8
+	Keys      [][]byte
+	Values    []uint64
+}
+
+// Item is the result of one key of a request
+type Item struct {
+	Value              uint64
+	Ok, Exist, NoSpace bool
+}
+
+// Response is a protocol response; Err is the server error message
+type Response struct {
+	Err   string
+	Items []Item
+}
+
+// WriteRequest encodes the request to w; a write error is sticky and is
+// reported by the flush
+func WriteRequest(w *bufio.Writer, req *Request) error {
+
+	if len(req.Keys) > MaxBatch {
+		return ErrBatch
+	}
+	var size = 4
+	var insert = req.Op&^(FlagRaw|FlagUpdate) == OpInsert
+	for _, key := range req.Keys {
+		if len(key) > 1<<16-1 {
+			return ErrFrame
+		}
+		size += 2 + len(key)
+		if insert {
+			size += 8
+		}
+	}
+	if size > MaxFrame {
+		return ErrBatch
+	}
+
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:], uint32(size))
+	b[4], b[5] = req.Op, req.Table
+	binary.BigEndian.PutUint16(b[6:], uint16(len(req.Keys)))
+	w.Write(b[:])
+	for i, key := range req.Keys {
+		binary.BigEndian.PutUint16(b[:], uint16(len(key)))
+		w.Write(b[:2])
+		w.Write(key)
+		if insert {
+			var value uint64
+			if i < len(req.Values) {
+				value = req.Values[i]
+			}
+			binary.BigEndian.PutUint64(b[:], value)
+			w.Write(b[:])
+		}
+	}
+	return nil
+}
+
+// ReadRequest decodes a request from r; the keys reference buf and are
+// valid until the next read into buf
+func ReadRequest(r *bufio.Reader, req *Request, buf *[]byte) error {
+
+	frame, err := readFrame(r, buf)
+	if err != nil {
+		return err
+	}
+	if len(frame) < 4 {
+		return ErrFrame
+	}
+
+	req.Op, req.Table = frame[0], frame[1]
+	var count = int(binary.BigEndian.Uint16(frame[2:]))
+	var insert = req.Op&^(FlagRaw|FlagUpdate) == OpInsert
+	req.Keys, req.Values = req.Keys[:0], req.Values[:0]
+	for frame = frame[4:]; count > 0; count-- {
+		if len(frame) < 2 {
+			return ErrFrame
+		}
+		size := int(binary.BigEndian.Uint16(frame))
+		if len(frame) < 2+size {
+			return ErrFrame
+		}
+		req.Keys = append(req.Keys, frame[2:2+size])
+		frame = frame[2+size:]
+		if insert {
+			if len(frame) < 8 {
+				return ErrFrame
+			}
+			req.Values = append(req.Values, binary.BigEndian.Uint64(frame))
+			frame = frame[8:]
+		}
+	}
+	if len(frame) != 0 {
+		return ErrFrame
+	}
+	return nil
+}
+
+// WriteResponse encodes the response to w; a write error is sticky and is
+// reported by the flush
+func WriteResponse(w *bufio.Writer, resp *Response) error {
+
+	var b [9]byte
+	if len(resp.Err) > 0 {
+		binary.BigEndian.PutUint32(b[:], uint32(1+len(resp.Err)))
+		b[4] = 1
+		w.Write(b[:5])
+		w.WriteString(resp.Err)
+		return nil
+	}
+
+	if len(resp.Items) > MaxBatch {
+		return ErrBatch
+	}
+	binary.BigEndian.PutUint32(b[:], uint32(3+9*len(resp.Items)))
+	b[4] = 0
+	binary.BigEndian.PutUint16(b[5:], uint16(len(resp.Items)))
+	w.Write(b[:7])
+	for _, item := range resp.Items {
+		b[0] = 0
+		if item.Ok {
+			b[0] |= itemOk
+		}
+		if item.Exist {
+			b[0] |= itemExist
+		}
+		if item.NoSpace {
+			b[0] |= itemNoSpace
+		}
+		binary.BigEndian.PutUint64(b[1:], item.Value)
+		w.Write(b[:])
+	}
+	return nil
+}
+
+// ReadResponse decodes a response from r
+func ReadResponse(r *bufio.Reader, resp *Response, buf *[]byte) error {
+
+	frame, err := readFrame(r, buf)
+	if err != nil {
+		return err
+	}
+	if len(frame) < 1 {
+		return ErrFrame
+	}
+
+	resp.Err, resp.Items = "", resp.Items[:0]
+	if frame[0] != 0 {
+		resp.Err = string(frame[1:])
+		if len(resp.Err) == 0 {
+			resp.Err = "unknown error"
+		}
+		return nil
+	}
+	if len(frame) < 3 {
+		return ErrFrame
+	}
+	var count = int(binary.BigEndian.Uint16(frame[1:]))
+	if frame = frame[3:]; len(frame) != 9*count {
+		return ErrFrame
+	}
+	for ; len(frame) > 0; frame = frame[9:] {
+		resp.Items = append(resp.Items, Item{
+			Value:   binary.BigEndian.Uint64(frame[1:]),
+			Ok:      frame[0]&itemOk != 0,
+			Exist:   frame[0]&itemExist != 0,
+			NoSpace: frame[0]&itemNoSpace != 0,
+		})
+	}
+	return nil
+}
+
+// readFrame reads a size prefixed frame into buf
+func readFrame(r *bufio.Reader, buf *[]byte) ([]byte, error) {
+
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(b[:])
+	if size > MaxFrame {
+		return nil, ErrFrame
+	}
+	if uint32(cap(*buf)) < size {
+		*buf = make([]byte, size)
+	}
+	frame := (*buf)[:size]
+	if _, err := io.ReadFull(r, frame); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return frame, nil
+}
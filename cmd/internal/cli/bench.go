@@ -0,0 +1,369 @@
+package cli
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/zxdev/kvs"
+	"github.com/zxdev/xxhash"
+)
+
+// bench builds a table from synthetic or supplied keys and measures the
+// insert throughput over the fill ratio, lookup hit and miss throughput at
+// 1..N goroutines each with its own lookup closure, removal, save and load,
+// and memory, compared against a go map of the key hashes
+//
+//	kvs bench [flags]
+//	kvs bench [flags] {source}
+func bench(args []string) int {
+
+	fs := flags("bench")
+	asJSON := fs.Bool("json", false, "json output")
+	kind := fs.String("type", "keon", "kvs type keon or keva")
+	n := fs.Int("n", 1000000, "synthetic keys; ignored with a source")
+	density := fs.Uint64("density", defaultDensity, "density padding factor")
+	width := fs.Uint64("width", 3, "bucket width")
+	shuffler := fs.Uint64("shuffler", 0, "shuffler cycles; 0 default")
+	tracker := fs.Int("tracker", 0, "shuffler tracker; 0 default")
+	goroutines := fs.Int("goroutines", runtime.GOMAXPROCS(0), "maximum lookup goroutines")
+	src := sourceFlags(fs)
+	if code, ok := parse(fs, args, 0); !ok {
+		return code
+	}
+	switch *kind {
+	case "keon", "keva":
+		src.keva = *kind == "keva"
+	default:
+		return failure("unknown type %q", *kind)
+	}
+	if *goroutines < 1 {
+		*goroutines = 1
+	}
+
+	// synthetic keys are generated into the caller buffer so that
+	// the key set does not consume memory in the comparison
+	var keys [][]byte
+	var key = func(i int, b []byte) []byte {
+		binary.BigEndian.PutUint64(b, uint64(i)*0x9e3779b97f4a7c15+1)
+		return b[:8]
+	}
+	if fs.NArg() > 0 {
+		if err := src.check(); err != nil {
+			fmt.Fprintf(stderr, "kvs: %v\n", err)
+			return exitUsage
+		}
+		err := src.read(fs.Arg(0), func(k []byte, _ uint64) { keys = append(keys, append([]byte(nil), k...)) })
+		if err != nil {
+			return failure("%v", err)
+		}
+		*n = len(keys)
+		key = func(i int, b []byte) []byte { return keys[i] }
+	}
+	if *n < 1 {
+		return failure("no keys")
+	}
+	miss := func(i int, b []byte) []byte { // never a synthetic or text key
+		binary.BigEndian.PutUint64(b, uint64(i)*0x9e3779b97f4a7c15+1)
+		b[8] = 0xff
+		return b[:9]
+	}
+
+	var r = benchResult{Type: *kind, Keys: *n, Density: *density, Width: *width, Goroutines: *goroutines}
+	var opt = &kvs.Option{Density: *density, Width: *width, Shuffler: *shuffler, Tracker: *tracker}
+	var t0 time.Time
+	var b [9]byte
+
+	// kvs insert over fill ratio deciles
+	heap := heapAlloc()
+	var tb = newBenchTable(*kind, uint64(*n), opt)
+	var insert = tb.insert()
+	var total time.Duration
+	for decile := 0; decile < 10; decile++ {
+		lo, hi := *n*decile/10, *n*(decile+1)/10
+		t0 = time.Now()
+		for i := lo; i < hi; i++ {
+			if !insert(key(i, b[:]), uint64(i)) {
+				return failure("count[%d] density[%d], width[%d]", *n, *density, *width)
+			}
+		}
+		elapsed := time.Since(t0)
+		total += elapsed
+		r.Fill = append(r.Fill, rate(hi-lo, elapsed))
+	}
+	r.KVS.Insert = rate(*n, total)
+	r.KVS.Memory = growth(heap)
+
+	// kvs lookup hits and misses with a lookup closure per goroutine
+	for g := 1; ; g *= 2 {
+		if g > *goroutines {
+			g = *goroutines
+		}
+		r.Levels = append(r.Levels, g)
+		r.KVS.Hit = append(r.KVS.Hit, parallel(g, *n, func() func(int, []byte) {
+			lookup := tb.lookup()
+			return func(i int, b []byte) { lookup(key(i, b)) }
+		}))
+		r.KVS.Miss = append(r.KVS.Miss, parallel(g, *n, func() func(int, []byte) {
+			lookup := tb.lookup()
+			return func(i int, b []byte) { lookup(miss(i, b)) }
+		}))
+		if g == *goroutines {
+			break
+		}
+	}
+
+	// kvs save and load
+	dir, err := os.MkdirTemp("", "kvs-bench-*")
+	if err != nil {
+		return failure("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bench."+*kind)
+	t0 = time.Now()
+	if err = tb.write(path); err != nil {
+		return failure("%v", err)
+	}
+	r.KVS.Save = time.Since(t0).Milliseconds()
+	if stat, err := os.Stat(path); err == nil {
+		r.KVS.File = stat.Size()
+	}
+	t0 = time.Now()
+	if err = tb.load(path); err != nil {
+		return failure("%v", err)
+	}
+	r.KVS.Load = time.Since(t0).Milliseconds()
+
+	// kvs remove
+	remove := tb.remove()
+	t0 = time.Now()
+	for i := 0; i < *n; i++ {
+		remove(key(i, b[:]))
+	}
+	r.KVS.Remove = rate(*n, time.Since(t0))
+	tb = nil
+
+	// go map of the key hashes
+	heap = heapAlloc()
+	var set map[uint64]struct{}
+	var kv map[uint64]uint64
+	t0 = time.Now()
+	if *kind == "keon" {
+		set = make(map[uint64]struct{})
+		for i := 0; i < *n; i++ {
+			set[xxhash.Sum(key(i, b[:]))] = struct{}{}
+		}
+	} else {
+		kv = make(map[uint64]uint64)
+		for i := 0; i < *n; i++ {
+			kv[xxhash.Sum(key(i, b[:]))] = uint64(i)
+		}
+	}
+	r.Map.Insert = rate(*n, time.Since(t0))
+	r.Map.Memory = growth(heap)
+
+	lookup := func(k []byte) {
+		if set != nil {
+			_ = set[xxhash.Sum(k)]
+			return
+		}
+		_ = kv[xxhash.Sum(k)]
+	}
+	for _, g := range r.Levels {
+		r.Map.Hit = append(r.Map.Hit, parallel(g, *n, func() func(int, []byte) {
+			return func(i int, b []byte) { lookup(key(i, b)) }
+		}))
+		r.Map.Miss = append(r.Map.Miss, parallel(g, *n, func() func(int, []byte) {
+			return func(i int, b []byte) { lookup(miss(i, b)) }
+		}))
+	}
+
+	t0 = time.Now()
+	for i := 0; i < *n; i++ {
+		if set != nil {
+			delete(set, xxhash.Sum(key(i, b[:])))
+			continue
+		}
+		delete(kv, xxhash.Sum(key(i, b[:])))
+	}
+	r.Map.Remove = rate(*n, time.Since(t0))
+	runtime.KeepAlive(set)
+	runtime.KeepAlive(kv)
+
+	if *asJSON {
+		json.NewEncoder(stdout).Encode(r)
+		return exitOk
+	}
+	r.write()
+	return exitOk
+}
+
+// benchResult is the bench measurement; rates are operations per second
+type benchResult struct {
+	Type       string    `json:"type"`
+	Keys       int       `json:"keys"`
+	Density    uint64    `json:"density"`
+	Width      uint64    `json:"width"`
+	Goroutines int       `json:"goroutines"`
+	Levels     []int     `json:"levels"` // goroutines per hit and miss rate
+	Fill       []float64 `json:"fill"`   // kvs insert rate per fill decile
+	KVS        benchRate `json:"kvs"`
+	Map        benchRate `json:"map"`
+}
+
+// benchRate is the measurement of a kvs table or a go map
+type benchRate struct {
+	Insert float64   `json:"insert"`
+	Hit    []float64 `json:"hit"`
+	Miss   []float64 `json:"miss"`
+	Remove float64   `json:"remove"`
+	Memory uint64    `json:"memory"`
+	Save   int64     `json:"save_ms,omitempty"`
+	Load   int64     `json:"load_ms,omitempty"`
+	File   int64     `json:"file,omitempty"`
+}
+
+// write the bench result as text
+func (r benchResult) write() {
+
+	fmt.Fprintln(stdout, "\n  bench", r.Type, r.Keys, "keys", "density", r.Density, "width", r.Width, runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintln(stdout, "---------------------------------------------------------")
+	fmt.Fprintln(stdout, "insert by fill ratio")
+	for i, v := range r.Fill {
+		fmt.Fprintf(stdout, "  %3d%%-%3d%%  %12.0f/s\n", i*10, (i+1)*10, v)
+	}
+
+	fmt.Fprintf(stdout, "\n%-18s %14s %14s %8s\n", "", "kvs", "map", "kvs/map")
+	row := func(name string, k, m float64) {
+		var ratio float64
+		if m > 0 {
+			ratio = k / m
+		}
+		fmt.Fprintf(stdout, "%-18s %14.0f %14.0f %8.2f\n", name, k, m, ratio)
+	}
+	row("insert/s", r.KVS.Insert, r.Map.Insert)
+	for i, g := range r.Levels {
+		row(fmt.Sprintf("lookup hit/s  x%d", g), r.KVS.Hit[i], r.Map.Hit[i])
+	}
+	for i, g := range r.Levels {
+		row(fmt.Sprintf("lookup miss/s x%d", g), r.KVS.Miss[i], r.Map.Miss[i])
+	}
+	row("remove/s", r.KVS.Remove, r.Map.Remove)
+	row("memory bytes", float64(r.KVS.Memory), float64(r.Map.Memory))
+
+	fmt.Fprintf(stdout, "\nsave       : %d ms\n", r.KVS.Save)
+	fmt.Fprintf(stdout, "load       : %d ms\n", r.KVS.Load)
+	fmt.Fprintf(stdout, "file       : %d bytes\n\n", r.KVS.File)
+}
+
+// benchTable is a kvs table of either type
+type benchTable struct {
+	keon *kvs.KEON
+	keva *kvs.KEVA
+}
+
+// newBenchTable of the kind
+func newBenchTable(kind string, n uint64, opt *kvs.Option) *benchTable {
+	if kind == "keva" {
+		return &benchTable{keva: kvs.NewKEVA(n, opt)}
+	}
+	return &benchTable{keon: kvs.NewKEON(n, opt)}
+}
+
+// insert reports false on NoSpace
+func (tb *benchTable) insert() func(k []byte, v uint64) bool {
+	if tb.keon != nil {
+		insert := tb.keon.Insert(false)
+		return func(k []byte, _ uint64) bool { return !insert(k).NoSpace }
+	}
+	insert := tb.keva.Insert(false)
+	return func(k []byte, v uint64) bool { return !insert(k, v).NoSpace }
+}
+
+func (tb *benchTable) lookup() func(k []byte) bool {
+	if tb.keon != nil {
+		return tb.keon.Lookup()
+	}
+	lookup := tb.keva.Lookup()
+	return func(k []byte) bool { return lookup(k).Ok }
+}
+
+func (tb *benchTable) remove() func(k []byte) {
+	if tb.keon != nil {
+		remove := tb.keon.Remove()
+		return func(k []byte) { remove(k) }
+	}
+	remove := tb.keva.Remove()
+	return func(k []byte) { remove(k) }
+}
+
+func (tb *benchTable) write(path string) error {
+	if tb.keon != nil {
+		return tb.keon.Write(path)
+	}
+	return tb.keva.Write(path)
+}
+
+// load replaces the table with the table loaded from path
+func (tb *benchTable) load(path string) error {
+	var ok bool
+	if tb.keon != nil {
+		tb.keon = nil
+		tb.keon, ok = kvs.LoadKEON(path)
+	} else {
+		tb.keva = nil
+		tb.keva, ok = kvs.LoadKEVA(path)
+	}
+	if !ok {
+		return fmt.Errorf("load failure %s", path)
+	}
+	return nil
+}
+
+// parallel runs n operations split across g goroutines, each with its own
+// operation from newOp, and reports the aggregate operations per second
+func parallel(g, n int, newOp func() func(i int, b []byte)) float64 {
+	var wg sync.WaitGroup
+	var t0 = time.Now()
+	for w := 0; w < g; w++ {
+		wg.Add(1)
+		go func(lo, hi int, op func(int, []byte)) {
+			defer wg.Done()
+			var b [9]byte
+			for i := lo; i < hi; i++ {
+				op(i, b[:])
+			}
+		}(n*w/g, n*(w+1)/g, newOp())
+	}
+	wg.Wait()
+	return rate(n, time.Since(t0))
+}
+
+// rate is operations per second
+func rate(n int, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds()
+}
+
+// growth is the live heap growth since heap
+func growth(heap uint64) uint64 {
+	if now := heapAlloc(); now > heap {
+		return now - heap
+	}
+	return 0
+}
+
+// heapAlloc is the live heap after a collection
+func heapAlloc() uint64 {
+	var m runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"net"
+
+	"github.com/zxdev/kvs"
+	"github.com/zxdev/kvs/client"
+	"github.com/zxdev/xxhash"
+)
+
+// binServer serves the tables over the client package binary protocol
+type binServer struct {
+	*server
+	conns
+}
+
+// handle the requests of a connection; responses are flushed once the
+// pipelined requests already received have been answered
+func (bs *binServer) handle(conn net.Conn) {
+
+	var r = bufio.NewReaderSize(conn, 64*1024)
+	var w = bufio.NewWriterSize(conn, 64*1024)
+	var buf []byte
+	var req client.Request
+	var resp client.Response
+	for {
+		if err := client.ReadRequest(r, &req, &buf); err != nil {
+			if errors.Is(err, client.ErrFrame) {
+				client.WriteResponse(w, &client.Response{Err: err.Error()})
+				w.Flush()
+			}
+			return
+		}
+		resp.Err, resp.Items = "", resp.Items[:0]
+		if err := bs.request(&req, &resp); err != nil {
+			resp.Err, resp.Items = err.Error(), resp.Items[:0]
+		}
+		client.WriteResponse(w, &resp)
+		if r.Buffered() == 0 {
+			if w.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// request executes the request against its table
+func (bs *binServer) request(req *client.Request, resp *client.Response) error {
+
+	var op, raw, update = req.Op &^ (client.FlagRaw | client.FlagUpdate), req.Op&client.FlagRaw != 0, req.Op&client.FlagUpdate != 0
+	if op == client.OpTable {
+		if len(req.Keys) != 1 {
+			return errors.New("table requires one name")
+		}
+		var item client.Item
+		for i, name := range bs.names {
+			if name == string(req.Keys[0]) && i <= 0xff {
+				item.Ok, item.Value = true, uint64(i)<<32|bs.tables[name].Info().Signature
+			}
+		}
+		resp.Items = append(resp.Items, item)
+		return nil
+	}
+
+	if int(req.Table) >= len(bs.names) {
+		return errors.New("unknown table")
+	}
+	var h = bs.tables[bs.names[req.Table]]
+	if raw {
+		for _, key := range req.Keys {
+			if len(key) != 8 {
+				return errors.New("raw key is not 8 bytes")
+			}
+		}
+	}
+	var hash = func(key []byte) uint64 {
+		if raw {
+			return binary.BigEndian.Uint64(key)
+		}
+		return xxhash.Sum(key)
+	}
+
+	switch op {
+	case client.OpLookup:
+		for _, key := range req.Keys {
+			item := h.RawLookup(hash(key))
+			resp.Items = append(resp.Items, client.Item{Value: item.Value, Ok: item.Ok})
+		}
+
+	case client.OpLen:
+		resp.Items = append(resp.Items, client.Item{Value: h.Len(), Ok: true})
+
+	case client.OpInsert, client.OpRemove:
+		if !bs.admin {
+			return errors.New("read only; kvs serve -admin enables updates")
+		}
+		var key [8]byte
+		return bs.update(h, func(keon *kvs.KEON, keva *kvs.KEVA) error {
+			switch {
+			case op == client.OpRemove:
+				var remove func([]byte) struct{ Ok, Exist bool }
+				if keon != nil {
+					remove = keon.RawRemove()
+				} else {
+					remove = keva.RawRemove()
+				}
+				for _, k := range req.Keys {
+					binary.BigEndian.PutUint64(key[:], hash(k))
+					item := remove(key[:])
+					resp.Items = append(resp.Items, client.Item{Ok: item.Ok, Exist: item.Exist})
+				}
+
+			case keon != nil:
+				insert := keon.RawInsert(update)
+				for _, k := range req.Keys {
+					binary.BigEndian.PutUint64(key[:], hash(k))
+					item := insert(key[:])
+					resp.Items = append(resp.Items, client.Item{Ok: item.Ok, Exist: item.Exist, NoSpace: item.NoSpace})
+				}
+
+			default:
+				// an update sets the value as the http and resp updates do
+				insert, remove, lookup := keva.RawInsert(update), keva.RawRemove(), keva.RawLookup()
+				for i, k := range req.Keys {
+					binary.BigEndian.PutUint64(key[:], hash(k))
+					if prior := lookup(key[:]); update && prior.Ok && prior.Value != req.Values[i] {
+						ok, err := setValue(insert, remove, key[:], prior.Value, req.Values[i])
+						if err != nil {
+							return err
+						}
+						resp.Items = append(resp.Items, client.Item{Ok: ok, Exist: true, NoSpace: !ok})
+						continue
+					}
+					item := insert(key[:], req.Values[i])
+					resp.Items = append(resp.Items, client.Item{Ok: item.Ok, Exist: item.Exist, NoSpace: item.NoSpace})
+				}
+			}
+			return nil
+		})
+
+	default:
+		return errors.New("unknown op")
+	}
+
+	return nil
+}
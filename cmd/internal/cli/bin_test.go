@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/zxdev/kvs"
+	"github.com/zxdev/kvs/client"
+)
+
+// go test -v -run BinRequest
+func TestBinRequest(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "t.keva")
+	kn := kvs.NewKEVA(100, nil)
+	kn.Insert(false)([]byte("a"), 1)
+	kn.Write(path)
+	s, err := newServer([]string{path}, 0)
+	if err != nil {
+		t.Log("server failure", err)
+		t.FailNow()
+	}
+	defer s.close()
+	s.admin = true
+	h := s.tables["t"]
+
+	// an insert leaves the value of an existing key and an update sets
+	// the value as the http and resp updates do
+	var bs = &binServer{server: s}
+	for _, tc := range []struct {
+		op    uint8
+		key   string
+		value uint64
+		item  client.Item
+		after uint64
+	}{
+		{client.OpInsert, "a", 2, client.Item{Exist: true}, 1},
+		{client.OpInsert | client.FlagUpdate, "a", 3, client.Item{Ok: true, Exist: true}, 3},
+		{client.OpInsert | client.FlagUpdate, "a", 3, client.Item{Ok: true, Exist: true}, 3},
+		{client.OpInsert | client.FlagUpdate, "b", 4, client.Item{Ok: true}, 4},
+	} {
+		var resp client.Response
+		err := bs.request(&client.Request{Op: tc.op, Keys: [][]byte{[]byte(tc.key)}, Values: []uint64{tc.value}}, &resp)
+		if err != nil || len(resp.Items) != 1 || resp.Items[0] != tc.item || h.Lookup([]byte(tc.key)).Value != tc.after {
+			t.Log(tc.op, tc.key, "request failure", err, resp.Items, h.Lookup([]byte(tc.key)))
+			t.FailNow()
+		}
+	}
+
+}
@@ -0,0 +1,195 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zxdev/kvs"
+)
+
+// build a keon or keva from a source file or stdin with - in a single pass
+// by staging the key hashes, spilling to a temporary file as needed, so the
+// source can be a pipe; the source format is described by the source flags
+// and malformed lines are reported with line numbers and skipped, or fail
+// the build with -strict
+//
+//	kvs build [flags] {source}
+//	kvs build [flags] {out} {source}
+func build(args []string) int {
+
+	fs := flags("build")
+	kind := fs.String("type", "", "kvs type keon or keva; default from -out extension or keon")
+	out := fs.String("out", "", "output path; default {source}.{type}")
+	density := fs.Uint64("density", defaultDensity, "density padding factor")
+	width := fs.Uint64("width", 3, "bucket width")
+	shuffler := fs.Uint64("shuffler", 0, "shuffler cycles; 0 default")
+	tracker := fs.Int("tracker", 0, "shuffler tracker; 0 default")
+	memory := fs.Int("memory", 0, "staged keys held in memory before spilling to a temporary file; 0 default")
+	withStats := fs.Bool("stats", false, "report the insert shuffle statistics of the build")
+	src := sourceFlags(fs)
+	if code, ok := parse(fs, args, 1); !ok {
+		return code
+	}
+
+	source := fs.Arg(0)
+	if fs.NArg() == 2 {
+		*out, source = fs.Arg(0), fs.Arg(1)
+	}
+	if len(*kind) == 0 {
+		*kind = "keon"
+		if filepath.Ext(*out) == ".keva" {
+			*kind = "keva"
+		}
+	}
+	if len(*out) == 0 {
+		if source == "-" {
+			fmt.Fprintln(stderr, "kvs: an output path is required with stdin")
+			return exitUsage
+		}
+		*out = source + "." + *kind
+	}
+	switch *kind {
+	case "keon", "keva":
+		src.keva = *kind == "keva"
+	default:
+		return failure("unknown type %q", *kind)
+	}
+	if err := src.check(); err != nil {
+		fmt.Fprintf(stderr, "kvs: %v\n", err)
+		return exitUsage
+	}
+
+	var add func(key []byte, value uint64)
+	var stageKEON *kvs.StageKEON
+	var stageKEVA *kvs.StageKEVA
+	switch *kind {
+	case "keon":
+		stageKEON = kvs.NewStageKEON(*memory)
+		defer stageKEON.Close()
+		add = func(key []byte, _ uint64) { stageKEON.Add(key) }
+	case "keva":
+		stageKEVA = kvs.NewStageKEVA(*memory)
+		defer stageKEVA.Close()
+		add = stageKEVA.Add
+	}
+
+	// stage the records; a strict build fails before the table is built
+	if err := src.read(source, add); err != nil {
+		return failure("%v", err)
+	}
+
+	// an interrupted build or write leaves no output file
+	ctx, cancel := interrupt()
+	defer cancel()
+
+	var opt = &kvs.Option{Density: *density, Width: *width, Shuffler: *shuffler, Tracker: *tracker}
+	var line progress
+	var count uint64
+	var err error
+	switch *kind {
+	case "keon":
+		var kv *kvs.KEON
+		count = stageKEON.Len()
+		if kv, err = stageKEON.BuildContext(ctx, opt, line.report("build")); err == nil {
+			err = kv.WriteContext(ctx, *out, line.report("write"))
+		}
+		line.end()
+		if *withStats && kv != nil {
+			shuffleStats(kv.Stats())
+		}
+	case "keva":
+		var kv *kvs.KEVA
+		count = stageKEVA.Len()
+		if kv, err = stageKEVA.BuildContext(ctx, opt, line.report("build")); err == nil {
+			err = kv.WriteContext(ctx, *out, line.report("write"))
+		}
+		line.end()
+		if *withStats && kv != nil {
+			shuffleStats(kv.Stats())
+		}
+	}
+
+	switch {
+	case count == 0:
+		return failure("empty source %s", source)
+	case errors.Is(err, kvs.ErrNoSpace):
+		return failure("count[%d] density[%d], width[%d]", count, *density, *width)
+	case err != nil:
+		return failure("%v", err)
+	}
+	return exitOk
+}
+
+// shuffleStats reports the insert shuffle statistics of a build
+func shuffleStats(s struct {
+	Direct, Displaced uint64
+	Swaps             [16]uint64
+	Aborts            uint64
+	Full, Exhausted   uint64
+	Onset             float64
+}) {
+	fmt.Fprintln(stdout, "\n  insert shuffle")
+	fmt.Fprintln(stdout, "---------------------------------")
+	fmt.Fprintln(stdout, "direct     :", s.Direct)
+	fmt.Fprintln(stdout, "displaced  :", s.Displaced)
+	for i, n := range s.Swaps {
+		switch {
+		case n == 0:
+		case i == 0:
+			fmt.Fprintf(stdout, "  swaps    : 1 %d\n", n)
+		case i == len(s.Swaps)-1:
+			fmt.Fprintf(stdout, "  swaps    : %d+ %d\n", 1<<i, n)
+		default:
+			fmt.Fprintf(stdout, "  swaps    : %d-%d %d\n", 1<<i, 1<<(i+1)-1, n)
+		}
+	}
+	fmt.Fprintln(stdout, "aborts     :", s.Aborts)
+	fmt.Fprintln(stdout, "nospace    :", s.Full, "full", s.Exhausted, "exhausted")
+	fmt.Fprintf(stdout, "onset      : %.4f fill\n\n", s.Onset)
+}
+
+// Alias runs the legacy kvs-keon and kvs-keva builder commands using the
+// DENSITY, WIDTH, and DELIMITER environment settings as kvs build
+//
+//	DENSITY={n} WIDTH={n} kvs-keon {file}
+//	DENSITY={n} WIDTH={n} DELIMITER={v} kvs-keva {file}
+func Alias(name string, args []string) int {
+
+	var kind = strings.TrimPrefix(name, "kvs-")
+	if len(args) != 1 {
+		switch kind {
+		case "keva":
+			fmt.Fprintln(stderr, "DENSITY={n} WIDTH={n} DELIMITER={v} kvs-keva {file}")
+		default:
+			fmt.Fprintln(stderr, "DENSITY={n} WIDTH={n} kvs-keon {file}")
+		}
+		return exitUsage
+	}
+
+	return Run(aliasArgs(kind, args[0]))
+}
+
+// aliasArgs maps the legacy environment settings to the kvs build arguments;
+// an unset or zero DENSITY is the legacy density of 5 rather than the kvs
+// build default and an unset or zero WIDTH is the legacy and build width of 3
+func aliasArgs(kind, path string) []string {
+
+	var build = []string{"build", "-type", kind}
+	for _, env := range [][3]string{{"DENSITY", "-density", "5"}, {"WIDTH", "-width", ""}, {"DELIMITER", "-delimiter", ""}} {
+		v := os.Getenv(env[0])
+		if env[0] != "DELIMITER" {
+			if n, _ := strconv.Atoi(v); n == 0 {
+				v = env[2] // legacy default
+			}
+		}
+		if len(v) > 0 && !(env[0] == "DELIMITER" && kind == "keon") {
+			build = append(build, env[1], v)
+		}
+	}
+
+	return append(build, path)
+}
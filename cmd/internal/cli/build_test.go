@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+)
+
+// go test -v -run AliasArgs
+func TestAliasArgs(t *testing.T) {
+
+	for _, tc := range []struct {
+		kind                      string
+		density, width, delimiter string
+		args                      []string
+	}{
+		{kind: "keon",
+			args: []string{"build", "-type", "keon", "-density", "5", "f"}},
+		{kind: "keon", density: "0", width: "0", delimiter: "=",
+			args: []string{"build", "-type", "keon", "-density", "5", "f"}},
+		{kind: "keva", density: "x", width: "4", delimiter: "=",
+			args: []string{"build", "-type", "keva", "-density", "5", "-width", "4", "-delimiter", "=", "f"}},
+		{kind: "keva", density: "30",
+			args: []string{"build", "-type", "keva", "-density", "30", "f"}},
+	} {
+		t.Setenv("DENSITY", tc.density)
+		t.Setenv("WIDTH", tc.width)
+		t.Setenv("DELIMITER", tc.delimiter)
+		if args := aliasArgs(tc.kind, "f"); !reflect.DeepEqual(args, tc.args) {
+			t.Log(tc.kind, tc.density, "alias failure", args)
+			t.Fail()
+		}
+	}
+
+}
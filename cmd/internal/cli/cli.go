@@ -0,0 +1,283 @@
+package cli
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/zxdev/kvs"
+)
+
+/*
+	cli implements the kvs command and its subcommands so that the kvs,
+	kvs-keon, and kvs-keva binaries share one implementation
+
+	exit codes
+		0 success
+		1 failure
+		2 usage error
+
+*/
+
+// exit codes
+const (
+	exitOk      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// defaultDensity is the density flag default of the commands that build a
+// table, which is the kvs Option default
+const defaultDensity = 25
+
+// command is a kvs subcommand
+type command struct {
+	name, args, summary string
+	run                 func(args []string) int
+}
+
+// commands in help order
+var commands []command
+
+func init() {
+	commands = []command{
+		{"info", "{file}", "describe a kvs file header", info},
+		{"get", "{file} {key} ...", "lookup keys in a kvs file", get},
+		{"build", "[out] {source}", "build a keon or keva from a source file or stdin", build},
+		{"merge", "{out.keon} {file} {file} ...", "merge keon files into a new keon", merge},
+		{"diff", "{file} {file}", "compare the keys of two kvs files", diff},
+		{"verify", "{file}", "deep integrity check of a kvs file", verify},
+		{"inspect", "{file}", "render the bucket layout of a kvs file", inspect},
+		{"stats", "{file}", "bucket occupancy and insert headroom of a kvs file", stats},
+		{"export", "{file}", "export the hashes and values of a kvs file as text", export},
+		{"import", "{out} {export}", "build a kvs file from an export", imports},
+		{"serve", "{file} ...", "serve kvs file lookups over http, resp, and binary protocols", serve},
+		{"tune", "{source}", "recommend build options for a key set", tune},
+		{"bench", "[source]", "benchmark a kvs table against a go map", bench},
+		{"help", "[command]", "show help for a command", help},
+	}
+}
+
+// stdout and stderr are the command outputs
+var stdout, stderr io.Writer = os.Stdout, os.Stderr
+
+// Run the kvs command with args excluding the program name and return the exit code.
+func Run(args []string) int {
+
+	if len(args) == 0 {
+		usage()
+		return exitUsage
+	}
+
+	for _, c := range commands {
+		if args[0] == c.name {
+			return c.run(args[1:])
+		}
+	}
+
+	switch args[0] {
+	case "-h", "-help", "--help":
+		usage()
+		return exitOk
+	}
+
+	// legacy argument count dispatch
+	//	kvs {file}
+	//	kvs {file} {key,key,key}
+	if _, err := resolve(args[0]); err == nil {
+		switch len(args) {
+		case 1:
+			return info(args)
+		case 2:
+			return get(append([]string{args[0]}, strings.Split(args[1], ",")...))
+		}
+	}
+
+	fmt.Fprintf(stderr, "kvs: unknown command %q\n", args[0])
+	usage()
+	return exitUsage
+}
+
+// usage writes the kvs command summary
+func usage() {
+	fmt.Fprintln(stderr, "usage: kvs {command} [flags] [args]")
+	fmt.Fprintln(stderr, "\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(stderr, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(stderr, "\nlegacy:")
+	fmt.Fprintln(stderr, "  kvs {file}                 same as kvs info {file}")
+	fmt.Fprintln(stderr, "  kvs {file} {key,key,key}   same as kvs get {file} {key} ...")
+	fmt.Fprintln(stderr, "\nuse \"kvs help {command}\" for the command flags")
+}
+
+// help for a command
+func help(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return exitOk
+	}
+	for _, c := range commands {
+		if args[0] == c.name && c.name != "help" {
+			return c.run([]string{"-h"})
+		}
+	}
+	fmt.Fprintf(stderr, "kvs: unknown command %q\n", args[0])
+	return exitUsage
+}
+
+// flags is the *flag.FlagSet for a command with the consistent usage text
+func flags(name string) *flag.FlagSet {
+	var fs = flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		for _, c := range commands {
+			if c.name == name {
+				fmt.Fprintf(stderr, "usage: kvs %s [flags] %s\n\n  %s\n", c.name, c.args, c.summary)
+			}
+		}
+		var any bool
+		fs.VisitAll(func(*flag.Flag) { any = true })
+		if any {
+			fmt.Fprintln(stderr, "\nflags:")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// parse the command flags and report the exit code on help or a usage error
+func parse(fs *flag.FlagSet, args []string, n int) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOk, false
+		}
+		return exitUsage, false
+	}
+	if fs.NArg() < n {
+		fs.Usage()
+		return exitUsage, false
+	}
+	return exitOk, true
+}
+
+// failure writes the error and returns the failure exit code
+func failure(format string, a ...interface{}) int {
+	fmt.Fprintf(stderr, "kvs: "+format+"\n", a...)
+	return exitFailure
+}
+
+// header is the kvs.Info file header information
+type header = struct {
+	Signature, Checksum, Timestamp, Count, Max uint64 // externals
+	Depth, Width, Density, Shuffler, Tracker   uint64 // internals
+	Ok                                         bool   // status
+}
+
+// table is a loaded kvs file of either type
+type table struct {
+	path string
+	info header
+	keon *kvs.KEON
+	keva *kvs.KEVA
+}
+
+// kind is the kvs type name for the header signature
+func kind(signature uint64) string {
+	switch signature {
+	case 0xff01:
+		return "keon"
+	case 0xff02:
+		return "keva"
+	}
+	return "unknown"
+}
+
+// resolve senses the kvs file extensions for user convenience; the header
+// signature is what determines the kvs structure type and not the extension
+func resolve(path string) (string, error) {
+	for _, p := range []string{path, path + ".keon", path + ".keva"} {
+		if info := kvs.Info(p); info.Ok && kind(info.Signature) != "unknown" {
+			return p, nil
+		}
+	}
+	return path, errors.New("invalid resource " + path)
+}
+
+// load the kvs file at path and validate the checksum and signature
+func load(path string) (*table, error) {
+
+	path, err := resolve(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ok bool
+	var t = &table{path: path, info: kvs.Info(path)}
+	switch t.info.Signature {
+	case 0xff01:
+		t.keon, ok = kvs.LoadKEON(path)
+	case 0xff02:
+		t.keva, ok = kvs.LoadKEVA(path)
+	}
+	if !ok {
+		return nil, errors.New("checksum failure " + path)
+	}
+
+	return t, nil
+}
+
+// each calls fn with every raw key and value in the table; value is 0 for a keon
+func (t *table) each(fn func(k *[8]byte, v uint64)) {
+	var k, v [8]byte
+	switch {
+	case t.keon != nil:
+		next := t.keon.Export()
+		for next(&k) {
+			fn(&k, 0)
+		}
+	case t.keva != nil:
+		next := t.keva.Export()
+		for next(&k, &v) {
+			fn(&k, binary.BigEndian.Uint64(v[:]))
+		}
+	}
+}
+
+// lookup is a lookup of a key for either type; value is 0 for a keon
+func (t *table) lookup() func(k []byte) (uint64, bool) {
+	if t.keon != nil {
+		lookup := t.keon.Lookup()
+		return func(k []byte) (uint64, bool) { return 0, lookup(k) }
+	}
+	lookup := t.keva.Lookup()
+	return func(k []byte) (uint64, bool) {
+		item := lookup(k)
+		return item.Value, item.Ok
+	}
+}
+
+// rawLookup is a lookup of a raw key for either type; value is 0 for a keon
+func (t *table) rawLookup() func(k []byte) (uint64, bool) {
+	if t.keon != nil {
+		lookup := t.keon.RawLookup()
+		return func(k []byte) (uint64, bool) { return 0, lookup(k) }
+	}
+	lookup := t.keva.RawLookup()
+	return func(k []byte) (uint64, bool) {
+		item := lookup(k)
+		return item.Value, item.Ok
+	}
+}
+
+// len is the table count
+func (t *table) len() uint64 {
+	if t.keon != nil {
+		return t.keon.Len()
+	}
+	return t.keva.Len()
+}
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// diff compares the keys, and the values when both are a keva, of two
+// kvs files; the exit code is 0 when identical, 1 when different, and
+// 2 on a failure, like diff
+//
+//	kvs diff [flags] {file} {file}
+func diff(args []string) int {
+
+	fs := flags("diff")
+	list := fs.Bool("list", false, "list each difference as -hash, +hash, or ~hash a b")
+	if code, ok := parse(fs, args, 2); !ok {
+		return code
+	}
+
+	a, err := load(fs.Arg(0))
+	if err != nil {
+		failure("%v", err)
+		return exitUsage
+	}
+	b, err := load(fs.Arg(1))
+	if err != nil {
+		failure("%v", err)
+		return exitUsage
+	}
+
+	var removed, added, changed, common uint64
+	var values = a.keva != nil && b.keva != nil
+
+	inB := b.rawLookup()
+	a.each(func(k *[8]byte, v uint64) {
+		w, ok := inB(k[:])
+		switch {
+		case !ok:
+			removed++
+			if *list {
+				fmt.Fprintf(stdout, "-%016x\n", binary.BigEndian.Uint64(k[:]))
+			}
+		case values && v != w:
+			changed++
+			if *list {
+				fmt.Fprintf(stdout, "~%016x %d %d\n", binary.BigEndian.Uint64(k[:]), v, w)
+			}
+		default:
+			common++
+		}
+	})
+
+	inA := a.rawLookup()
+	b.each(func(k *[8]byte, v uint64) {
+		if _, ok := inA(k[:]); !ok {
+			added++
+			if *list {
+				fmt.Fprintf(stdout, "+%016x\n", binary.BigEndian.Uint64(k[:]))
+			}
+		}
+	})
+
+	if !*list {
+		fmt.Fprintf(stdout, "%s %d %s %d\n", a.path, a.len(), b.path, b.len())
+		fmt.Fprintln(stdout, "only in a  :", removed)
+		fmt.Fprintln(stdout, "only in b  :", added)
+		if values {
+			fmt.Fprintln(stdout, "changed    :", changed)
+		}
+		fmt.Fprintln(stdout, "common     :", common)
+	}
+
+	if removed+added+changed > 0 {
+		return exitFailure
+	}
+	return exitOk
+}
@@ -0,0 +1,353 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zxdev/kvs"
+)
+
+// export formats
+const (
+	exportHex   = "hex"
+	exportCSV   = "csv"
+	exportJSONL = "jsonl"
+	exportRaw   = "raw"
+)
+
+// exportRecord is an exported hash and the keva value with the optional slot
+// index and row; the hash is hex so that it survives tools that use float64
+type exportRecord struct {
+	Hash  string  `json:"hash"`
+	Value *uint64 `json:"value,omitempty"`
+	Slot  *uint64 `json:"slot,omitempty"`
+	Row   *uint64 `json:"row,omitempty"`
+}
+
+// export writes the hash, and value for a keva, of every occupied slot as
+//
+//	hex    {hash} [value] [slot row]   space delimited hex; slot and row decimal
+//	csv    hash[,value][,slot,row]      with a header row
+//	jsonl  {"hash":"..","value":n,"slot":n,"row":n}
+//	raw    the Export 8 or 16 byte big endian records
+//
+//	kvs export [flags] {file}
+func export(args []string) int {
+
+	fs := flags("export")
+	format := fs.String("format", exportHex, "export format hex, csv, jsonl, or raw")
+	slots := fs.Bool("slot", false, "include the slot index and row")
+	out := fs.String("out", "", "output path; default stdout")
+	if code, ok := parse(fs, args, 1); !ok {
+		return code
+	}
+	switch *format {
+	case exportHex, exportCSV, exportJSONL:
+	case exportRaw:
+		if *slots {
+			fmt.Fprintln(stderr, "kvs: -slot is not supported by the raw format")
+			return exitUsage
+		}
+	default:
+		fmt.Fprintf(stderr, "kvs: unknown format %q\n", *format)
+		return exitUsage
+	}
+
+	t, err := load(fs.Arg(0))
+	if err != nil {
+		return failure("%v", err)
+	}
+
+	var dst io.Writer = stdout
+	var f *os.File
+	if len(*out) > 0 {
+		if f, err = os.Create(*out); err != nil {
+			return failure("%v", err)
+		}
+		defer f.Close()
+		dst = f
+	}
+	var w = bufio.NewWriterSize(dst, 1<<20)
+
+	var keva = t.keva != nil
+	if *format == exportCSV {
+		var head = "hash"
+		if keva {
+			head += ",value"
+		}
+		if *slots {
+			head += ",slot,row"
+		}
+		fmt.Fprintln(w, head)
+	}
+
+	var enc = json.NewEncoder(w)
+	var raw [16]byte
+	t.eachSlot(func(slot uint64, k *[8]byte, v uint64) {
+		var hash = binary.BigEndian.Uint64(k[:])
+		var row = slot / t.info.Width
+		switch *format {
+		case exportRaw:
+			copy(raw[:8], k[:])
+			if !keva {
+				w.Write(raw[:8])
+				return
+			}
+			binary.BigEndian.PutUint64(raw[8:], v)
+			w.Write(raw[:])
+
+		case exportHex:
+			fmt.Fprintf(w, "%016x", hash)
+			if keva {
+				fmt.Fprintf(w, " %016x", v)
+			}
+			if *slots {
+				fmt.Fprintf(w, " %d %d", slot, row)
+			}
+			w.WriteByte('\n')
+
+		case exportCSV:
+			fmt.Fprintf(w, "%016x", hash)
+			if keva {
+				fmt.Fprintf(w, ",%d", v)
+			}
+			if *slots {
+				fmt.Fprintf(w, ",%d,%d", slot, row)
+			}
+			w.WriteByte('\n')
+
+		case exportJSONL:
+			var r = exportRecord{Hash: fmt.Sprintf("%016x", hash)}
+			if keva {
+				r.Value = &v
+			}
+			if *slots {
+				r.Slot, r.Row = &slot, &row
+			}
+			enc.Encode(r)
+		}
+	})
+
+	if err = w.Flush(); err == nil && f != nil {
+		err = f.Close()
+	}
+	if err != nil {
+		return failure("%v", err)
+	}
+	return exitOk
+}
+
+// eachSlot calls fn with the slot index, raw key, and value of every
+// occupied slot in the table; value is 0 for a keon
+func (t *table) eachSlot(fn func(slot uint64, k *[8]byte, v uint64)) {
+	var k, v [8]byte
+	switch {
+	case t.keon != nil:
+		next := t.keon.ExportSlot()
+		for slot, ok := next(&k); ok; slot, ok = next(&k) {
+			fn(slot, &k, 0)
+		}
+	case t.keva != nil:
+		next := t.keva.ExportSlot()
+		for slot, ok := next(&k, &v); ok; slot, ok = next(&k, &v) {
+			fn(slot, &k, binary.BigEndian.Uint64(v[:]))
+		}
+	}
+}
+
+// import builds a table from a kvs export in any export format by inserting
+// the raw hashes, so the table can be rebuilt with other options; the slot
+// and row of an export are ignored since the table is arranged again
+//
+//	kvs import [flags] {out} {export}
+func imports(args []string) int {
+
+	fs := flags("import")
+	format := fs.String("format", exportHex, "export format hex, csv, jsonl, or raw")
+	kind := fs.String("type", "", "kvs type keon or keva; default from the out extension or keon")
+	density := fs.Uint64("density", defaultDensity, "density padding factor")
+	width := fs.Uint64("width", 3, "bucket width")
+	shuffler := fs.Uint64("shuffler", 0, "shuffler cycles; 0 default")
+	tracker := fs.Int("tracker", 0, "shuffler tracker; 0 default")
+	if code, ok := parse(fs, args, 2); !ok {
+		return code
+	}
+	out, source := fs.Arg(0), fs.Arg(1)
+	if len(*kind) == 0 {
+		*kind = "keon"
+		if filepath.Ext(out) == ".keva" {
+			*kind = "keva"
+		}
+	}
+	var keva bool
+	switch *kind {
+	case "keon", "keva":
+		keva = *kind == "keva"
+	default:
+		return failure("unknown type %q", *kind)
+	}
+	switch *format {
+	case exportHex, exportCSV, exportJSONL, exportRaw:
+	default:
+		fmt.Fprintf(stderr, "kvs: unknown format %q\n", *format)
+		return exitUsage
+	}
+
+	var add func(hash, value uint64)
+	var stageKEON *kvs.StageKEON
+	var stageKEVA *kvs.StageKEVA
+	if keva {
+		stageKEVA = kvs.NewStageKEVA(0)
+		defer stageKEVA.Close()
+		add = stageKEVA.RawAdd
+	} else {
+		stageKEON = kvs.NewStageKEON(0)
+		defer stageKEON.Close()
+		add = func(hash, _ uint64) { stageKEON.RawAdd(hash) }
+	}
+
+	r, err := open(source)
+	if err != nil {
+		return failure("%v", err)
+	}
+	err = readExport(r, *format, keva, add)
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return failure("%s: %v", source, err)
+	}
+
+	var opt = &kvs.Option{Density: *density, Width: *width, Shuffler: *shuffler, Tracker: *tracker}
+	var count uint64
+	if keva {
+		var kv *kvs.KEVA
+		count = stageKEVA.Len()
+		if kv, err = stageKEVA.Build(opt); err == nil {
+			err = kv.Write(out)
+		}
+	} else {
+		var kn *kvs.KEON
+		count = stageKEON.Len()
+		if kn, err = stageKEON.Build(opt); err == nil {
+			err = kn.Write(out)
+		}
+	}
+
+	switch {
+	case count == 0:
+		return failure("empty export %s", source)
+	case errors.Is(err, kvs.ErrNoSpace):
+		return failure("count[%d] density[%d], width[%d]", count, *density, *width)
+	case err != nil:
+		return failure("%v", err)
+	}
+	return exitOk
+}
+
+// readExport reads the export records and calls add with the hash and value;
+// a malformed record fails the import with the line number, or the record
+// number of a raw export
+func readExport(r io.Reader, format string, keva bool, add func(hash, value uint64)) error {
+
+	if format == exportRaw {
+		var record = 8
+		if keva {
+			record = 16
+		}
+		var b [16]byte
+		var buf = bufio.NewReaderSize(r, 1<<20)
+		for i := 1; ; i++ {
+			n, err := io.ReadFull(buf, b[:record])
+			switch {
+			case err == io.EOF:
+				return nil
+			case err == io.ErrUnexpectedEOF:
+				return fmt.Errorf("truncated record of %d bytes", n)
+			case err != nil:
+				return err
+			}
+			hash := binary.BigEndian.Uint64(b[:8])
+			if hash == 0 {
+				return fmt.Errorf("record %d: zero hash", i)
+			}
+			var value uint64
+			if keva {
+				value = binary.BigEndian.Uint64(b[8:])
+			}
+			add(hash, value)
+		}
+	}
+
+	var line int
+	var hash, value uint64
+	var err error
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		switch format {
+		case exportHex:
+			fields := strings.Fields(string(text))
+			hash, err = strconv.ParseUint(fields[0], 16, 64)
+			if err == nil && keva {
+				if len(fields) < 2 {
+					err = errors.New("missing value")
+				} else {
+					value, err = strconv.ParseUint(fields[1], 16, 64)
+				}
+			}
+
+		case exportCSV:
+			if line == 1 && bytes.HasPrefix(text, []byte("hash")) {
+				continue // header row
+			}
+			fields := strings.Split(string(text), ",")
+			hash, err = strconv.ParseUint(fields[0], 16, 64)
+			if err == nil && keva {
+				if len(fields) < 2 {
+					err = errors.New("missing value")
+				} else {
+					value, err = strconv.ParseUint(fields[1], 10, 64)
+				}
+			}
+
+		case exportJSONL:
+			var record exportRecord
+			if err = json.Unmarshal(text, &record); err == nil {
+				hash, err = strconv.ParseUint(record.Hash, 16, 64)
+			}
+			value = 0
+			if err == nil && keva {
+				if record.Value == nil {
+					err = errors.New("missing value")
+				} else {
+					value = *record.Value
+				}
+			}
+		}
+
+		if err == nil && hash == 0 {
+			err = errors.New("zero hash")
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		add(hash, value)
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// go test -v -run ReadExport
+func TestReadExport(t *testing.T) {
+
+	for _, tc := range []struct {
+		format string
+		keva   bool
+		in     string
+		sum    uint64 // sum of the hashes and values
+		err    string
+	}{
+		{format: exportHex, keva: true, in: "0a 1\n\n0b 2\n", sum: 24},
+		{format: exportHex, in: "0\n", err: "line 1: zero hash"},
+		{format: exportCSV, keva: true, in: "hash,value\n0a,1\n", sum: 11},
+		{format: exportJSONL, keva: true, in: "{\"hash\":\"0a\"}\n", err: "line 1: missing value"},
+		{format: exportRaw, in: string([]byte{0, 0, 0, 0, 0, 0, 0, 10}), sum: 10},
+		{format: exportRaw, keva: true, in: string([]byte{0, 0, 0, 0, 0, 0, 0, 10, 0, 0, 0, 0, 0, 0, 0, 1}), sum: 11},
+		{format: exportRaw, in: string(make([]byte, 8)), err: "record 1: zero hash"},
+		{format: exportRaw, in: string(make([]byte, 5)), err: "truncated record of 5 bytes"},
+	} {
+		var sum uint64
+		err := readExport(bytes.NewReader([]byte(tc.in)), tc.format, tc.keva, func(hash, value uint64) { sum += hash + value })
+		if (err == nil) != (len(tc.err) == 0) || err != nil && !strings.Contains(err.Error(), tc.err) || err == nil && sum != tc.sum {
+			t.Log(tc.format, tc.keva, "read export failure", err, sum)
+			t.Fail()
+		}
+	}
+
+}
@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// output formats
+const (
+	formatText = "text"
+	formatJSON = "json"
+	formatTSV  = "tsv"
+)
+
+// formats adds the -format and -json flags to a command
+func formats(fs *flag.FlagSet) func() (string, bool) {
+	format := fs.String("format", formatText, "output format text, json, or tsv")
+	asJSON := fs.Bool("json", false, "json output; same as -format=json")
+	return func() (string, bool) {
+		if *asJSON {
+			*format = formatJSON
+		}
+		switch *format {
+		case formatText, formatJSON, formatTSV:
+			return *format, true
+		}
+		fmt.Fprintf(stderr, "kvs: unknown format %q\n", *format)
+		return *format, false
+	}
+}
+
+// infoRecord is the machine-readable kvs file header information
+type infoRecord struct {
+	Path      string  `json:"path"`
+	Signature string  `json:"signature"`
+	Checksum  uint64  `json:"checksum"`
+	Timestamp string  `json:"timestamp"`
+	Capacity  uint64  `json:"capacity"`
+	Count     uint64  `json:"count"`
+	Depth     uint64  `json:"depth"`
+	Width     uint64  `json:"width"`
+	Density   uint64  `json:"density"`
+	Shuffler  uint64  `json:"shuffler"`
+	Tracker   uint64  `json:"tracker"`
+	Fill      float64 `json:"fill"`
+	Memory    uint64  `json:"memory"`
+}
+
+// newInfoRecord from the kvs file header information
+func newInfoRecord(path string, info header) infoRecord {
+	var r = infoRecord{
+		Path:      path,
+		Signature: kind(info.Signature),
+		Checksum:  info.Checksum,
+		Timestamp: time.Unix(int64(info.Timestamp), 0).UTC().Format(time.RFC3339),
+		Capacity:  info.Max,
+		Count:     info.Count,
+		Depth:     info.Depth,
+		Width:     info.Width,
+		Density:   info.Density,
+		Shuffler:  info.Shuffler,
+		Tracker:   info.Tracker,
+	}
+	if slots := info.Depth * info.Width; slots > 0 {
+		r.Fill = float64(info.Count) / float64(slots)
+		r.Memory = slots * 8
+		if info.Signature == 0xff02 {
+			r.Memory *= 2
+		}
+	}
+	return r
+}
+
+// write the info record as json or tsv with a header row
+func (r infoRecord) write(w io.Writer, format string) {
+	switch format {
+	case formatJSON:
+		json.NewEncoder(w).Encode(r)
+	case formatTSV:
+		fmt.Fprintln(w, "path\tsignature\tchecksum\ttimestamp\tcapacity\tcount\tdepth\twidth\tdensity\tshuffler\ttracker\tfill\tmemory")
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%d\n", r.Path, r.Signature, r.Checksum, r.Timestamp,
+			r.Capacity, r.Count, r.Depth, r.Width, r.Density, r.Shuffler, r.Tracker, strconv.FormatFloat(r.Fill, 'f', 6, 64), r.Memory)
+	}
+}
+
+// lookupRecord is the machine-readable lookup result; the value
+// is only present for a keva
+type lookupRecord struct {
+	Key   string  `json:"key"`
+	Found bool    `json:"found"`
+	Value *uint64 `json:"value,omitempty"`
+	Hex   string  `json:"hex,omitempty"`
+}
+
+// newLookupRecord for key; keva reports the value
+func newLookupRecord(key string, found, keva bool, value uint64) lookupRecord {
+	var r = lookupRecord{Key: key, Found: found}
+	if keva && found {
+		r.Value = &value
+		r.Hex = fmt.Sprintf("%016x", value)
+	}
+	return r
+}
+
+// write the lookup record as json lines or tsv
+func (r lookupRecord) write(w io.Writer, format string) {
+	switch format {
+	case formatJSON:
+		json.NewEncoder(w).Encode(r)
+	case formatTSV:
+		var value string
+		if r.Value != nil {
+			value = strconv.FormatUint(*r.Value, 10)
+		}
+		fmt.Fprintf(w, "%s\t%v\t%s\t%s\n", r.Key, r.Found, value, r.Hex)
+	}
+}
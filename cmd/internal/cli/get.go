@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// get looks up keys in a kvs file; the keys are the arguments or newline
+// delimited keys read from stdin with - or from a file with -keys so that
+// the table is loaded once and get can be used as a filter over a stream,
+// like grep -F -f; a filter exits 1 when nothing is selected, like grep
+//
+//	kvs get [flags] {file} {key} ...
+//	kvs get [flags] {file} -
+//	kvs get [flags] -keys {keys} {file}
+func get(args []string) int {
+
+	fs := flags("get")
+	format := formats(fs)
+	keys := fs.String("keys", "", "read newline delimited keys from file")
+	hits := fs.Bool("hits", false, "only report hits; text output is the key as a filter")
+	misses := fs.Bool("misses", false, "only report misses; text output is the key as a filter")
+	if code, ok := parse(fs, args, 1); !ok {
+		return code
+	}
+	f, ok := format()
+	if !ok {
+		return exitUsage
+	}
+	if *hits && *misses {
+		fmt.Fprintln(stderr, "kvs: -hits and -misses are exclusive")
+		return exitUsage
+	}
+
+	var stream io.Reader
+	switch {
+	case len(*keys) > 0:
+		r, err := os.Open(*keys)
+		if err != nil {
+			return failure("%v", err)
+		}
+		defer r.Close()
+		stream = r
+	case fs.NArg() == 2 && fs.Arg(1) == "-":
+		stream = os.Stdin
+	case fs.NArg() < 2:
+		fs.Usage()
+		return exitUsage
+	}
+
+	t, err := load(fs.Arg(0))
+	if err != nil {
+		return failure("%v", err)
+	}
+
+	var w = bufio.NewWriter(stdout)
+	defer w.Flush()
+	if f == formatTSV {
+		fmt.Fprintln(w, "key\tfound\tvalue\thex")
+	}
+
+	var selected bool
+	var b [8]byte
+	var lookup = t.lookup()
+	var filter = *hits || *misses
+	emit := func(key []byte) {
+		value, found := lookup(key)
+		if filter && found != *hits {
+			return
+		}
+		selected = true
+
+		switch {
+		case f != formatText:
+			newLookupRecord(string(key), found, t.keva != nil, value).write(w, f)
+		case filter:
+			w.Write(key)
+			w.WriteByte('\n')
+		case t.keon != nil:
+			fmt.Fprintln(w, "keon:", string(key), found)
+		case value == 0:
+			fmt.Fprintf(w, "keva: %s %v\n", key, found)
+		default:
+			binary.LittleEndian.PutUint64(b[:], value)
+			fmt.Fprintf(w, "keva: %s %v %v\n", key, found, b)
+		}
+	}
+
+	if stream != nil {
+		scanner := bufio.NewScanner(stream)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			emit(scanner.Bytes())
+		}
+		if err = scanner.Err(); err != nil {
+			w.Flush()
+			return failure("%v", err)
+		}
+	} else {
+		for _, v := range fs.Args()[1:] {
+			emit([]byte(v))
+		}
+	}
+
+	if filter && !selected {
+		return exitFailure
+	}
+	return exitOk
+}
@@ -0,0 +1,235 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/zxdev/kvs"
+)
+
+/*
+	http endpoints of kvs serve
+
+		GET  /t                      table info for every table
+		GET  /t/{table}              table info
+		GET  /t/{table}/{key}        lookup; the key is path escaped
+		POST /t/{table}              batch lookup of a json array of keys
+		                             or newline delimited keys as json lines
+		GET  /healthz                200 when the process is up
+		GET  /readyz                 200 when accepting requests
+		GET  /metrics                prometheus text exposition of the tables
+		POST /admin/reload[/{table}] reload the tables from disk
+		POST /admin/patch/{table}    apply a json merge patch; ?save=1 saves
+		                             {"insert":[{"key":"k","value":1}],"remove":["k"]}
+
+*/
+
+// maxBody limits request bodies
+const maxBody = 64 << 20
+
+// ServeHTTP routes the kvs serve endpoints
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	path, err := url.PathUnescape(r.URL.EscapedPath())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	path = strings.TrimPrefix(path, "/")
+	route, rest, _ := strings.Cut(path, "/")
+
+	switch route {
+	case "healthz":
+		io.WriteString(w, "ok\n")
+
+	case "readyz":
+		if !s.ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok\n")
+
+	case "metrics":
+		if method(w, r, http.MethodGet) {
+			s.metrics.ServeHTTP(w, r)
+		}
+
+	case "t":
+		name, key, lookup := strings.Cut(rest, "/")
+		if len(name) == 0 {
+			if !method(w, r, http.MethodGet) {
+				return
+			}
+			var info []interface{}
+			for _, name := range s.names {
+				info = append(info, s.info(name))
+			}
+			writeJSON(w, http.StatusOK, info)
+			return
+		}
+		h, ok := s.tables[name]
+		if !ok {
+			http.Error(w, "unknown table "+name, http.StatusNotFound)
+			return
+		}
+		switch {
+		case lookup:
+			if method(w, r, http.MethodGet) {
+				writeJSON(w, http.StatusOK, s.lookup(h, key))
+			}
+		case r.Method == http.MethodPost:
+			s.batch(w, r, h)
+		case method(w, r, http.MethodGet):
+			writeJSON(w, http.StatusOK, s.info(name))
+		}
+
+	case "admin":
+		if !s.admin {
+			http.Error(w, "admin endpoints are disabled", http.StatusForbidden)
+			return
+		}
+		if !method(w, r, http.MethodPost) {
+			return
+		}
+		action, name, _ := strings.Cut(rest, "/")
+		s.adminHTTP(w, r, action, name)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// info is the table header information with the live count
+func (s *server) info(name string) interface{} {
+	h := s.tables[name]
+	return struct {
+		Table string `json:"table"`
+		infoRecord
+		Len uint64 `json:"len"`
+	}{name, newInfoRecord(s.paths[name], h.Info()), h.Len()}
+}
+
+// lookup the key in the table
+func (s *server) lookup(h *kvs.Handle, key string) lookupRecord {
+	item := h.Lookup([]byte(key))
+	return newLookupRecord(key, item.Ok, h.Info().Signature == 0xff02, item.Value)
+}
+
+// batch lookup of a json array of keys as a json array, or newline
+// delimited keys as json lines
+func (s *server) batch(w http.ResponseWriter, r *http.Request, h *kvs.Handle) {
+
+	var body = bufio.NewReader(http.MaxBytesReader(w, r.Body, maxBody))
+	var asJSON = strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+	if !asJSON {
+		for {
+			b, err := body.Peek(1)
+			if err != nil || !bytes.ContainsAny(b, " \t\r\n") {
+				asJSON = err == nil && b[0] == '['
+				break
+			}
+			body.ReadByte()
+		}
+	}
+
+	if asJSON {
+		var keys []string
+		if err := json.NewDecoder(body).Decode(&keys); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var records = make([]lookupRecord, 0, len(keys))
+		for _, key := range keys {
+			records = append(records, s.lookup(h, key))
+		}
+		writeJSON(w, http.StatusOK, records)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	var bw = bufio.NewWriter(w)
+	var enc = json.NewEncoder(bw)
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		enc.Encode(s.lookup(h, scanner.Text()))
+	}
+	bw.Flush()
+}
+
+// adminHTTP reloads the tables or applies a merge patch
+func (s *server) adminHTTP(w http.ResponseWriter, r *http.Request, action, name string) {
+
+	switch action {
+	case "reload":
+		var names = s.names
+		if len(name) > 0 {
+			if _, ok := s.tables[name]; !ok {
+				http.Error(w, "unknown table "+name, http.StatusNotFound)
+				return
+			}
+			names = []string{name}
+		}
+		type result struct {
+			Table string `json:"table"`
+			Ok    bool   `json:"ok"`
+			Error string `json:"error,omitempty"`
+		}
+		var results []result
+		var status = http.StatusOK
+		for _, name := range names {
+			var res = result{Table: name, Ok: true}
+			if err := s.tables[name].Reload(); err != nil {
+				res.Ok, res.Error, status = false, err.Error(), http.StatusInternalServerError
+			}
+			results = append(results, res)
+		}
+		writeJSON(w, status, results)
+
+	case "patch":
+		h, ok := s.tables[name]
+		if !ok {
+			http.Error(w, "unknown table "+name, http.StatusNotFound)
+			return
+		}
+		var p patch
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody)).Decode(&p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res, err := p.apply(s, h)
+		if err == nil && r.URL.Query().Get("save") == "1" {
+			err = h.Save()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// method reports when the request method is allowed or writes the error
+func method(w http.ResponseWriter, r *http.Request, allow string) bool {
+	if r.Method == allow || (allow == http.MethodGet && r.Method == http.MethodHead) {
+		return true
+	}
+	w.Header().Set("Allow", allow)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+// writeJSON writes v as the json response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
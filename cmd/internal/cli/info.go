@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/zxdev/kvs"
+)
+
+// info describes the kvs file header
+//
+//	kvs info {file}
+func info(args []string) int {
+
+	fs := flags("info")
+	format := formats(fs)
+	if code, ok := parse(fs, args, 1); !ok {
+		return code
+	}
+	f, ok := format()
+	if !ok {
+		return exitUsage
+	}
+
+	path, err := resolve(fs.Arg(0))
+	if err != nil {
+		return failure("%v", err)
+	}
+	info := kvs.Info(path)
+
+	if f != formatText {
+		newInfoRecord(path, info).write(stdout, f)
+		return exitOk
+	}
+
+	const unit = 1024 // IEC units
+	var size uint64   // bytes per type
+	switch info.Signature {
+	case 0xff01:
+		size += 8
+	case 0xff02:
+		size += 16
+	}
+
+	fmt.Fprintln(stdout, "\n ", filepath.Base(path))
+	fmt.Fprintln(stdout, "---------------------------------")
+	fmt.Fprintln(stdout, "checksum   :", info.Checksum)
+	fmt.Fprintln(stdout, "timestamp  :", kind(info.Signature), info.Timestamp)
+	fmt.Fprintln(stdout, "capacity   :", info.Max)
+	fmt.Fprintln(stdout, "count      :", info.Count)
+	fmt.Fprintf(stdout, "format     : %d x %x\n", info.Depth, info.Width)
+	fmt.Fprintf(stdout, "density    : %d %d [%d]\n", info.Density, info.Depth*info.Width, (info.Depth*info.Width)-info.Count)
+	fmt.Fprintf(stdout, "shuffler   : %d x %d\n", info.Shuffler, info.Tracker)
+
+	var b = info.Depth * info.Width * size
+	if b > unit {
+		div, exp := int64(unit), 0
+		for n := b / unit; n >= unit; n /= unit {
+			div *= unit
+			exp++
+		}
+		fmt.Fprintf(stdout, "memory     : %.2f %ciB\n", float64(b)/float64(div), "KMGTPE"[exp])
+	}
+
+	fmt.Fprintln(stdout)
+	return exitOk
+}
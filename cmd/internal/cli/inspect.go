@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/zxdev/kvs"
+)
+
+// inspect renders the bucket layout of a kvs file; the summary and row
+// occupancy histogram, the grid and occupancy of a range of rows, and
+// the candidate rows for a key showing where it lives or why it is absent
+//
+//	kvs inspect [flags] {file}
+func inspect(args []string) int {
+
+	fs := flags("inspect")
+	rows := fs.String("rows", "", "row range from:to for -grid and -occupancy; default all")
+	grid := fs.Bool("grid", false, "render the bucket grid")
+	occupancy := fs.Bool("occupancy", false, "render the per row occupancy")
+	key := fs.String("key", "", "render the candidate rows for the key")
+	hash := fs.String("hash", "", "render the candidate rows for the hex key hash")
+	if code, ok := parse(fs, args, 1); !ok {
+		return code
+	}
+
+	var opt = &kvs.InspectOption{Grid: *grid, Occupancy: *occupancy}
+	if len(*rows) > 0 {
+		from, to, ok := strings.Cut(*rows, ":")
+		var err error
+		if opt.From, err = strconv.ParseUint(from, 10, 64); err == nil && ok && len(to) > 0 {
+			opt.To, err = strconv.ParseUint(to, 10, 64)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "kvs: invalid rows %q\n", *rows)
+			return exitUsage
+		}
+	}
+	switch {
+	case len(*key) > 0 && len(*hash) > 0:
+		fmt.Fprintln(stderr, "kvs: -key and -hash are exclusive")
+		return exitUsage
+	case len(*key) > 0:
+		opt.Key = []byte(*key)
+	case len(*hash) > 0:
+		h, err := strconv.ParseUint(strings.TrimPrefix(*hash, "0x"), 16, 64)
+		if err != nil {
+			fmt.Fprintf(stderr, "kvs: invalid hash %q\n", *hash)
+			return exitUsage
+		}
+		opt.Key, opt.Raw = binary.BigEndian.AppendUint64(nil, h), true
+	}
+
+	t, err := load(fs.Arg(0))
+	if err != nil {
+		return failure("%v", err)
+	}
+
+	if t.keon != nil {
+		err = t.keon.Inspect(stdout, opt)
+	} else {
+		err = t.keva.Inspect(stdout, opt)
+	}
+	if err != nil {
+		return failure("%v", err)
+	}
+	return exitOk
+}
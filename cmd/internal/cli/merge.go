@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/zxdev/kvs"
+)
+
+// merge many keon files into a new keon file
+//
+//	kvs merge [flags] {out.keon} {file} {file} ...
+func merge(args []string) int {
+
+	fs := flags("merge")
+	density := fs.Uint64("density", defaultDensity, "density padding factor")
+	width := fs.Uint64("width", 3, "bucket width")
+	shuffler := fs.Uint64("shuffler", 0, "shuffler cycles; 0 default")
+	tracker := fs.Int("tracker", 0, "shuffler tracker; 0 default")
+	if code, ok := parse(fs, args, 2); !ok {
+		return code
+	}
+
+	// an interrupted merge or write leaves no output file
+	ctx, cancel := interrupt()
+	defer cancel()
+
+	var line progress
+	path, paths := fs.Arg(0), fs.Args()[1:]
+	kv, r, err := kvs.MergeContext(ctx, paths, &kvs.Option{Density: *density, Width: *width, Shuffler: *shuffler, Tracker: *tracker}, line.report("merge"))
+	line.end()
+	if err != nil {
+		return failure("%v", err)
+	}
+	for _, src := range r.Sources {
+		fmt.Fprintf(stdout, "merge: %s ok[%v] count[%d] items[%d] checksum[%d]\n", src.Path, src.Ok, src.Count, src.Items, src.Checksum)
+	}
+
+	switch {
+	case r.Invalid:
+		return failure("invalid source")
+	case r.NoSpace:
+		return failure("count[%d] density[%d], width[%d]", kv.Cap(), *density, *width)
+	case !r.Ok:
+		return failure("composite checksum")
+	}
+
+	err = kv.WriteContext(ctx, path, line.report("write"))
+	line.end()
+	if err != nil {
+		return failure("%v", err)
+	}
+	fmt.Fprintf(stdout, "merge: %s count[%d] checksum[%d]\n", path, kv.Len(), kv.Checksum())
+
+	return exitOk
+}
@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/zxdev/kvs"
+)
+
+// interrupt is a context that is cancelled by SIGINT or SIGTERM so that a
+// long operation stops without replacing its output file
+func interrupt() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// progress is the progress line of the long operations on stderr
+type progress struct {
+	open bool // a line is written without the newline
+}
+
+// report is the kvs.Progress of the labeled operation, or nil when stderr
+// is not a terminal
+func (p *progress) report(label string) kvs.Progress {
+
+	f, ok := stderr.(*os.File)
+	if !ok {
+		return nil
+	}
+	if fi, err := f.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+
+	return func(done, total uint64, remaining time.Duration) {
+		var percent float64 = 100
+		if total > 0 {
+			percent = float64(done) * 100 / float64(total)
+		}
+		fmt.Fprintf(stderr, "\r\033[Kkvs: %s %5.1f%% %d of %d", label, percent, done, total)
+		if remaining > 0 {
+			fmt.Fprintf(stderr, " %v remaining", remaining.Round(time.Second))
+		}
+		if p.open = done < total; !p.open {
+			fmt.Fprintln(stderr)
+		}
+	}
+}
+
+// end the open progress line before other output
+func (p *progress) end() {
+	if p.open {
+		fmt.Fprintln(stderr)
+		p.open = false
+	}
+}
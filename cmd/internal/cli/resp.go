@@ -0,0 +1,320 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/zxdev/kvs"
+)
+
+/*
+	resp commands of kvs serve -resp
+
+		PING [message]             PONG or the message
+		ECHO message               the message
+		SELECT index               select the table by argument order
+		EXISTS key [key ...]       count of the keys that exist
+		SISMEMBER table key        1 when the key is in the named table
+		GET key                    keva value as a decimal string or nil
+		MGET key [key ...]         keva values; nil when absent or a keon
+		SET key value              keva insert or update with a uint64 value
+		DEL key [key ...]          count of the keys removed
+		DBSIZE                     live count of the table
+		INFO [section]             server and keyspace information
+		SAVE                       save the table to its file
+		QUIT                       close the connection
+
+	a key is {table}{prefix}{key} for a named table or else a key of the
+	table selected by SELECT; SET, DEL, and SAVE require -admin and the
+	keys of a DEL are removed with one update of each table
+
+*/
+
+// maxArgs limits the arguments of a resp command
+const maxArgs = 1 << 20
+
+// respArity is the argument count of each command including the command
+// name; a negative arity is the minimum count
+var respArity = map[string]int{
+	"ping": -1, "echo": 2, "select": 2, "exists": -2, "sismember": 3, "get": 2,
+	"mget": -2, "set": 3, "del": -2, "dbsize": 1, "info": -1, "save": 1,
+	"command": -1, "client": -1,
+}
+
+// respServer serves the tables over the redis serialization protocol
+type respServer struct {
+	*server
+	conns
+	prefix string // table key prefix separator; empty disables
+}
+
+// handle the commands of a connection; replies are flushed once the
+// pipelined commands already received have been answered
+func (rs *respServer) handle(conn net.Conn) {
+
+	var r = bufio.NewReaderSize(conn, 64*1024)
+	var w = bufio.NewWriter(conn)
+	var db int
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			var perr protocolError
+			if errors.As(err, &perr) {
+				fmt.Fprintf(w, "-ERR Protocol error: %s\r\n", perr)
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if strings.EqualFold(args[0], "quit") {
+			w.WriteString("+OK\r\n")
+			w.Flush()
+			return
+		}
+		rs.command(w, &db, args)
+		if r.Buffered() == 0 {
+			if w.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// command executes one command against the selected table
+func (rs *respServer) command(w *bufio.Writer, db *int, args []string) {
+
+	var name = strings.ToLower(args[0])
+	n, ok := respArity[name]
+	switch {
+	case !ok:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", args[0])
+		return
+	case (n > 0 && len(args) != n) || (n < 0 && len(args) < -n):
+		fmt.Fprintf(w, "-ERR wrong number of arguments for '%s' command\r\n", name)
+		return
+	case !rs.admin && (name == "set" || name == "del" || name == "save"):
+		w.WriteString("-READONLY You can't write against a read only replica.\r\n")
+		return
+	}
+
+	switch name {
+	case "ping":
+		if len(args) > 1 {
+			writeBulk(w, args[1])
+			return
+		}
+		w.WriteString("+PONG\r\n")
+
+	case "echo":
+		writeBulk(w, args[1])
+
+	case "select":
+		i, err := strconv.Atoi(args[1])
+		if err != nil || i < 0 || i >= len(rs.names) {
+			w.WriteString("-ERR DB index is out of range\r\n")
+			return
+		}
+		*db = i
+		w.WriteString("+OK\r\n")
+
+	case "exists":
+		var count int
+		for _, key := range args[1:] {
+			h, key := rs.route(*db, key)
+			count += int(b2u(h.Lookup([]byte(key)).Ok))
+		}
+		fmt.Fprintf(w, ":%d\r\n", count)
+
+	case "sismember":
+		h, ok := rs.tables[args[1]]
+		fmt.Fprintf(w, ":%d\r\n", b2u(ok && h.Lookup([]byte(args[2])).Ok))
+
+	case "get":
+		h, key := rs.route(*db, args[1])
+		if h.Info().Signature != 0xff02 {
+			w.WriteString("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+			return
+		}
+		rs.get(w, h, key)
+
+	case "mget":
+		fmt.Fprintf(w, "*%d\r\n", len(args)-1)
+		for _, key := range args[1:] {
+			h, key := rs.route(*db, key)
+			if h.Info().Signature != 0xff02 {
+				w.WriteString("$-1\r\n")
+				continue
+			}
+			rs.get(w, h, key)
+		}
+
+	case "set":
+		h, key := rs.route(*db, args[1])
+		if h.Info().Signature != 0xff02 {
+			w.WriteString("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+			return
+		}
+		value, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			w.WriteString("-ERR value is not an integer or out of range\r\n")
+			return
+		}
+		var p patch
+		p.Insert = append(p.Insert, struct {
+			Key   string `json:"key"`
+			Value uint64 `json:"value"`
+		}{key, value})
+		r, err := p.apply(rs.server, h)
+		switch {
+		case err != nil:
+			fmt.Fprintf(w, "-ERR %s\r\n", err)
+		case r.NoSpace > 0:
+			w.WriteString("-ERR table is full\r\n")
+		default:
+			w.WriteString("+OK\r\n")
+		}
+
+	case "del":
+		// one patch per table since every update copies the table
+		var order []*kvs.Handle
+		var patches = make(map[*kvs.Handle]*patch)
+		for _, key := range args[1:] {
+			h, key := rs.route(*db, key)
+			if patches[h] == nil {
+				order, patches[h] = append(order, h), new(patch)
+			}
+			patches[h].Remove = append(patches[h].Remove, key)
+		}
+		var count uint64
+		for _, h := range order {
+			r, err := patches[h].apply(rs.server, h)
+			if err != nil {
+				fmt.Fprintf(w, "-ERR %s\r\n", err)
+				return
+			}
+			count += r.Removed
+		}
+		fmt.Fprintf(w, ":%d\r\n", count)
+
+	case "dbsize":
+		fmt.Fprintf(w, ":%d\r\n", rs.tables[rs.names[*db]].Len())
+
+	case "info":
+		var b strings.Builder
+		b.WriteString("# Server\r\nredis_mode:kvs\r\n")
+		fmt.Fprintf(&b, "process_id:%d\r\n\r\n# Keyspace\r\n", os.Getpid())
+		for i, name := range rs.names {
+			r := newInfoRecord(rs.paths[name], rs.tables[name].Info())
+			fmt.Fprintf(&b, "db%d:keys=%d,table=%s,type=%s,capacity=%d,fill=%.4f\r\n",
+				i, rs.tables[name].Len(), name, r.Signature, r.Capacity, r.Fill)
+		}
+		writeBulk(w, b.String())
+
+	case "save":
+		if err := rs.tables[rs.names[*db]].Save(); err != nil {
+			fmt.Fprintf(w, "-ERR %s\r\n", err)
+			return
+		}
+		w.WriteString("+OK\r\n")
+
+	case "command":
+		w.WriteString("*0\r\n")
+
+	case "client":
+		w.WriteString("+OK\r\n")
+	}
+}
+
+// route the key to the table named by its prefix or else the selected table
+func (rs *respServer) route(db int, key string) (*kvs.Handle, string) {
+	if len(rs.prefix) > 0 {
+		if name, rest, ok := strings.Cut(key, rs.prefix); ok {
+			if h, ok := rs.tables[name]; ok {
+				return h, rest
+			}
+		}
+	}
+	return rs.tables[rs.names[db]], key
+}
+
+// get writes the keva value as a decimal bulk string or nil
+func (rs *respServer) get(w *bufio.Writer, h *kvs.Handle, key string) {
+	if item := h.Lookup([]byte(key)); item.Ok {
+		writeBulk(w, strconv.FormatUint(item.Value, 10))
+		return
+	}
+	w.WriteString("$-1\r\n")
+}
+
+// writeBulk writes a resp bulk string
+func writeBulk(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+// protocolError is a malformed resp request
+type protocolError string
+
+func (e protocolError) Error() string { return string(e) }
+
+// readCommand reads a resp array of bulk strings or an inline command
+func readCommand(r *bufio.Reader) ([]string, error) {
+
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+
+	// a null or empty array is no command
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < -1 || n > maxArgs {
+		return nil, protocolError("invalid multibulk length")
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	var args = make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		if line, err = readLine(r); err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, protocolError(fmt.Sprintf("expected '$', got '%.1s'", line))
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBody {
+			return nil, protocolError("invalid bulk length")
+		}
+		var b = make([]byte, size+2)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		if b[size] != '\r' || b[size+1] != '\n' {
+			return nil, protocolError("invalid bulk terminator")
+		}
+		args = append(args, string(b[:size]))
+	}
+	return args, nil
+}
+
+// readLine reads a crlf or lf terminated line of at most the reader size
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", protocolError("line too long")
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zxdev/kvs"
+)
+
+// go test -v -run ReadCommand
+func TestReadCommand(t *testing.T) {
+
+	for _, tc := range []struct {
+		in    string
+		args  []string
+		proto bool // protocol error
+	}{
+		{in: "*1\r\n$4\r\nPING\r\n", args: []string{"PING"}},
+		{in: "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", args: []string{"GET", "k"}},
+		{in: "*1\n$4\nPING\r\n", args: []string{"PING"}},
+		{in: "PING hello\r\n", args: []string{"PING", "hello"}},
+		{in: "\r\n", args: []string{}},
+		{in: "*0\r\n"},
+		{in: "*-1\r\n"},
+		{in: "*-2\r\n", proto: true},
+		{in: "*x\r\n", proto: true},
+		{in: "*2000000\r\n", proto: true},
+		{in: "*1\r\n:4\r\n", proto: true},
+		{in: "*1\r\n$-1\r\n", proto: true},
+		{in: "*1\r\n$x\r\n", proto: true},
+		{in: "*1\r\n$4\r\nPINGxx", proto: true},
+	} {
+		args, err := readCommand(bufio.NewReader(strings.NewReader(tc.in)))
+		var perr protocolError
+		if tc.proto != errors.As(err, &perr) || (!tc.proto && err != nil) {
+			t.Logf("%q error %v", tc.in, err)
+			t.Fail()
+			continue
+		}
+		if !tc.proto && len(args)+len(tc.args) > 0 && !reflect.DeepEqual(args, tc.args) {
+			t.Logf("%q args %q", tc.in, args)
+			t.Fail()
+		}
+	}
+
+	// a truncated bulk string is an i/o error
+	if _, err := readCommand(bufio.NewReader(strings.NewReader("*1\r\n$4\r\nPI"))); err == nil {
+		t.Log("truncated bulk accepted")
+		t.Fail()
+	}
+
+}
+
+// go test -v -run RespServer
+func TestRespServer(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "t.keva")
+	kn := kvs.NewKEVA(1000, nil)
+	kn.Insert(false)([]byte("a"), 1)
+	if err := kn.Write(path); err != nil {
+		t.Log("write failure", err)
+		t.FailNow()
+	}
+	s, err := newServer([]string{path}, 0)
+	if err != nil {
+		t.Log("server failure", err)
+		t.FailNow()
+	}
+	defer s.close()
+	s.admin = true
+	s.limit(1000)
+
+	var rs = &respServer{server: s, prefix: ":"}
+	client, conn := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer conn.Close()
+		rs.handle(conn)
+	}()
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// a null array is skipped and the connection keeps serving
+	var r = bufio.NewReader(client)
+	for _, tc := range []struct{ in, out string }{
+		{"*-1\r\n*1\r\n$4\r\nPING\r\n", "+PONG"},
+		{"*0\r\nGET a\r\n", "$1"},
+		{"SET b 2\r\n", "+OK"},
+		{"GET t:b\r\n", "$1"},
+		{"EXISTS a b c\r\n", ":2"},
+		{"DEL a t:b c a\r\n", ":2"},
+		{"EXISTS a b\r\n", ":0"},
+		{"*-3\r\n", "-ERR Protocol error: invalid multibulk length"},
+	} {
+		if _, err := client.Write([]byte(tc.in)); err != nil {
+			t.Log("write failure", tc.in, err)
+			t.FailNow()
+		}
+		line, err := r.ReadString('\n')
+		if err != nil || strings.TrimRight(line, "\r\n") != tc.out {
+			t.Logf("%q reply %q %v", tc.in, line, err)
+			t.FailNow()
+		}
+		if tc.out[0] == '$' {
+			r.ReadString('\n') // bulk value
+		}
+	}
+
+}
@@ -0,0 +1,441 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/zxdev/kvs"
+	"github.com/zxdev/kvs/metrics"
+)
+
+// serve loads kvs files as named tables and serves lookups over http and
+// optionally the redis and binary protocols; a table is named by
+// {name}={file} or by the file name without extension, SIGHUP reloads
+// every table, and SIGINT or SIGTERM shuts down gracefully; a primary
+// ships the admin updates to the replicas that subscribe to its tables
+//
+// every admin update copies the whole table, see kvs.Handle.Update, so the
+// updates of each table are paced by -updates and a batch of keys is best
+// sent as one patch, DEL, or binary request
+//
+//	kvs serve [flags] {file} ...
+//	kvs serve [flags] {name}={file} ...
+func serve(args []string) int {
+
+	fs := flags("serve")
+	addr := fs.String("addr", ":8080", "http listen address; empty disables http")
+	resp := fs.String("resp", "", "redis protocol listen address or unix:{path}; eg. :6379")
+	bin := fs.String("bin", "", "binary protocol listen address or unix:{path}; eg. :7379")
+	prefix := fs.String("prefix", ":", "redis protocol {table}{prefix}{key} separator; empty disables")
+	watch := fs.Duration("watch", 0, "reload tables when the files change; eg. 1m")
+	admin := fs.Bool("admin", false, "enable the /admin endpoints and the resp and binary protocol updates")
+	primary := fs.String("primary", "", "replication listen address for replicas; eg. :7380")
+	replica := fs.String("replica", "", "replicate the tables from the primary replication address")
+	backlog := fs.Int("backlog", 100000, "replication backlog in mutations before a replica is sent a snapshot")
+	updates := fs.Int("updates", 100, "admin updates per second per table, each copies the table; 0 is unlimited")
+	if code, ok := parse(fs, args, 1); !ok {
+		return code
+	}
+	switch {
+	case len(*addr) == 0 && len(*resp) == 0 && len(*bin) == 0:
+		fmt.Fprintln(stderr, "kvs: -addr, -resp, or -bin is required")
+		return exitUsage
+	case len(*replica) > 0 && (*admin || len(*primary) > 0):
+		fmt.Fprintln(stderr, "kvs: -replica is exclusive of -admin and -primary")
+		return exitUsage
+	}
+
+	s, err := newServer(fs.Args(), *watch)
+	if err != nil {
+		return failure("%v", err)
+	}
+	defer s.close()
+	s.admin = *admin
+	s.limit(*updates)
+
+	var done = make(chan error, 4)
+	if len(*primary) > 0 {
+		var tables = make(map[string]*kvs.Primary)
+		for _, name := range s.names {
+			if tables[name], err = kvs.NewPrimary(s.tables[name], *backlog); err != nil {
+				return failure("%v", err)
+			}
+			s.primaries[s.tables[name]] = tables[name]
+		}
+		ln, err := net.Listen("tcp", *primary)
+		if err != nil {
+			return failure("%v", err)
+		}
+		defer ln.Close()
+		go func() { done <- kvs.ServeReplication(ln, tables) }()
+		fmt.Fprintf(stderr, "kvs: replicating %s on %s\n", strings.Join(s.names, ", "), *primary)
+	}
+	if len(*replica) > 0 {
+		for _, name := range s.names {
+			name := name
+			r := kvs.NewReplica(s.tables[name], *replica, name)
+			r.OnSnapshot = func(seq uint64) { fmt.Fprintf(stderr, "kvs: replica %s snapshot at %d\n", name, seq) }
+			r.OnError = func(err error) { fmt.Fprintf(stderr, "kvs: replica %s: %v\n", name, err) }
+			r.Start()
+			s.replicas = append(s.replicas, r)
+		}
+	}
+
+	var srv *http.Server
+	if len(*addr) > 0 {
+		srv = &http.Server{Addr: *addr, Handler: s, ReadHeaderTimeout: 10 * time.Second}
+		go func() { done <- srv.ListenAndServe() }()
+		fmt.Fprintf(stderr, "kvs: serving %s on %s\n", strings.Join(s.names, ", "), *addr)
+	}
+	var rs *respServer
+	if len(*resp) > 0 {
+		rs = &respServer{server: s, prefix: *prefix}
+		if rs.listener, err = listen(*resp); err != nil {
+			if srv != nil {
+				srv.Close()
+			}
+			return failure("%v", err)
+		}
+		go func() { done <- rs.serve(rs.handle) }()
+		fmt.Fprintf(stderr, "kvs: serving %s on %s resp\n", strings.Join(s.names, ", "), *resp)
+	}
+	var bs *binServer
+	if len(*bin) > 0 {
+		bs = &binServer{server: s}
+		if bs.listener, err = listen(*bin); err != nil {
+			if srv != nil {
+				srv.Close()
+			}
+			if rs != nil {
+				rs.shutdown()
+			}
+			return failure("%v", err)
+		}
+		go func() { done <- bs.serve(bs.handle) }()
+		fmt.Fprintf(stderr, "kvs: serving %s on %s bin\n", strings.Join(s.names, ", "), *bin)
+	}
+	s.ready.Store(true)
+
+	var shutdown = func() error {
+		s.ready.Store(false)
+		if rs != nil {
+			rs.shutdown()
+		}
+		if bs != nil {
+			bs.shutdown()
+		}
+		if srv != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			return srv.Shutdown(ctx)
+		}
+		return nil
+	}
+
+	var sig = make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	for {
+		select {
+		case err = <-done:
+			shutdown()
+			if err == nil || errors.Is(err, http.ErrServerClosed) {
+				return exitOk
+			}
+			return failure("%v", err)
+
+		case v := <-sig:
+			if v == syscall.SIGHUP {
+				for _, name := range s.names {
+					if err := s.tables[name].Reload(); err != nil {
+						fmt.Fprintf(stderr, "kvs: reload %s: %v\n", name, err)
+					}
+				}
+				continue
+			}
+			if err = shutdown(); err != nil {
+				return failure("%v", err)
+			}
+			return exitOk
+		}
+	}
+}
+
+// server is the set of named tables served by kvs serve
+type server struct {
+	names     []string                     // table names in argument order
+	tables    map[string]*kvs.Handle       // tables by name
+	paths     map[string]string            // table paths by name
+	primaries map[*kvs.Handle]*kvs.Primary // replicated tables
+	limits    map[*kvs.Handle]*limiter     // update pacing by table
+	replicas  []*kvs.Replica               // replica tables
+	metrics   *metrics.Prometheus          // table metrics
+	ready     atomic.Bool                  // accepting requests
+	admin     bool                         // admin endpoints enabled
+}
+
+// newServer loads the tables named by the specs {name}={file} or {file}
+func newServer(specs []string, watch time.Duration) (*server, error) {
+
+	var s = &server{tables: make(map[string]*kvs.Handle), paths: make(map[string]string),
+		primaries: make(map[*kvs.Handle]*kvs.Primary), metrics: metrics.New()}
+	for _, spec := range specs {
+		name, path, ok := strings.Cut(spec, "=")
+		if !ok {
+			path = spec
+			name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		if _, dup := s.tables[name]; dup || len(name) == 0 || strings.Contains(name, "/") {
+			s.close()
+			return nil, fmt.Errorf("invalid table name %q", name)
+		}
+
+		path, err := resolve(path)
+		if err == nil {
+			var h *kvs.Handle
+			if h, err = kvs.NewHandle(path); err == nil {
+				name := name
+				h.OnReload = func(info header) { fmt.Fprintf(stderr, "kvs: reload %s count %d\n", name, info.Count) }
+				h.OnReject = func(_ header, err error) { fmt.Fprintf(stderr, "kvs: reject %s: %v\n", name, err) }
+				h.SetMetrics(name, s.metrics)
+				s.metrics.Size(name, func() (uint64, uint64) { return h.Len(), h.Info().Max })
+				if watch > 0 {
+					h.Watch(watch)
+				}
+				s.names = append(s.names, name)
+				s.tables[name] = h
+				s.paths[name] = path
+			}
+		}
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// close stops the replication and the table watchers
+func (s *server) close() {
+	for _, r := range s.replicas {
+		r.Close()
+	}
+	for _, p := range s.primaries {
+		p.Close()
+	}
+	for _, h := range s.tables {
+		h.Close()
+	}
+}
+
+// limit the admin updates of every table to rate per second
+func (s *server) limit(rate int) {
+	if rate > 0 {
+		s.limits = make(map[*kvs.Handle]*limiter)
+		for _, h := range s.tables {
+			s.limits[h] = &limiter{every: time.Second / time.Duration(rate)}
+		}
+	}
+}
+
+// limiter paces the updates of a table since each update copies the table
+type limiter struct {
+	mu    sync.Mutex
+	next  time.Time
+	every time.Duration
+}
+
+// wait until the next update of the table is due
+func (l *limiter) wait() {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	d := l.next.Sub(now)
+	l.next = l.next.Add(l.every)
+	l.mu.Unlock()
+	time.Sleep(d)
+}
+
+// update the table through its primary when the table is replicated; the
+// update waits on the table limiter
+func (s *server) update(h *kvs.Handle, fn func(keon *kvs.KEON, keva *kvs.KEVA) error) error {
+	if l, ok := s.limits[h]; ok {
+		l.wait()
+	}
+	if p, ok := s.primaries[h]; ok {
+		return p.Update(fn)
+	}
+	return h.Update(fn)
+}
+
+// patch is an admin merge patch of keys to insert, or for a keva to insert
+// or update with a value, and keys to remove
+type patch struct {
+	Insert []struct {
+		Key   string `json:"key"`
+		Value uint64 `json:"value"`
+	} `json:"insert"`
+	Remove []string `json:"remove"`
+}
+
+// patchResult reports the outcome of each patch operation
+type patchResult struct {
+	Inserted uint64 `json:"inserted"`
+	Updated  uint64 `json:"updated"`
+	Exist    uint64 `json:"exist"`
+	NoSpace  uint64 `json:"nospace"`
+	Removed  uint64 `json:"removed"`
+	Missing  uint64 `json:"missing"`
+	Len      uint64 `json:"len"`
+}
+
+// apply the patch to the table; a keva value update is a remove and insert
+// since an insert of an existing key does not change the value, and a key
+// lost by an update fails the patch which leaves the table unchanged
+func (p *patch) apply(s *server, h *kvs.Handle) (r patchResult, err error) {
+	err = s.update(h, func(keon *kvs.KEON, keva *kvs.KEVA) error {
+		if keon != nil {
+			insert, remove := keon.Insert(false), keon.Remove()
+			for _, v := range p.Insert {
+				item := insert([]byte(v.Key))
+				r.Inserted, r.Exist, r.NoSpace = r.Inserted+b2u(item.Ok), r.Exist+b2u(item.Exist), r.NoSpace+b2u(item.NoSpace)
+			}
+			for _, k := range p.Remove {
+				item := remove([]byte(k))
+				r.Removed, r.Missing = r.Removed+b2u(item.Exist), r.Missing+b2u(!item.Exist)
+			}
+			r.Len = keon.Len()
+			return nil
+		}
+
+		insert, remove, lookup := keva.Insert(false), keva.Remove(), keva.Lookup()
+		for _, v := range p.Insert {
+			if item := lookup([]byte(v.Key)); item.Ok {
+				if item.Value != v.Value {
+					// a failed update is reported as NoSpace
+					ok, err := setValue(insert, remove, []byte(v.Key), item.Value, v.Value)
+					if err != nil {
+						return fmt.Errorf("update %q: %v", v.Key, err)
+					}
+					r.Updated, r.NoSpace = r.Updated+b2u(ok), r.NoSpace+b2u(!ok)
+					continue
+				}
+				r.Exist++
+				continue
+			}
+			item := insert([]byte(v.Key), v.Value)
+			r.Inserted, r.NoSpace = r.Inserted+b2u(item.Ok), r.NoSpace+b2u(item.NoSpace)
+		}
+		for _, k := range p.Remove {
+			item := remove([]byte(k))
+			r.Removed, r.Missing = r.Removed+b2u(item.Exist), r.Missing+b2u(!item.Exist)
+		}
+		r.Len = keva.Len()
+		return nil
+	})
+	return
+}
+
+// setValue updates the value of an existing keva key with a remove and an
+// insert since an insert of an existing key does not change the value; a
+// failed insert restores the prior value into the slot the remove freed and
+// reports false, or is an error when the key can not be restored
+func setValue(insert func([]byte, uint64) struct{ Ok, Exist, NoSpace bool },
+	remove func([]byte) struct{ Ok, Exist bool }, key []byte, prior, value uint64) (bool, error) {
+	remove(key)
+	if insert(key, value).Ok {
+		return true, nil
+	}
+	if !insert(key, prior).Ok {
+		return false, errors.New("key lost")
+	}
+	return false, nil
+}
+
+// b2u is 1 for true
+func b2u(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// conns tracks the connections of a socket listener for a graceful shutdown
+type conns struct {
+	listener net.Listener
+	mu       sync.Mutex
+	active   map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// listen on a tcp address or a unix socket given as unix:{path} or a path
+// with a separator
+func listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok || strings.ContainsRune(addr, os.PathSeparator) {
+		if !ok {
+			path = addr
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// serve accepts connections and calls handle for each until shutdown; a
+// panic of a handle closes only its connection
+func (c *conns) serve(handle func(net.Conn)) error {
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		c.mu.Lock()
+		if c.active == nil {
+			c.active = make(map[net.Conn]struct{})
+		}
+		c.active[conn] = struct{}{}
+		c.mu.Unlock()
+		c.wg.Add(1)
+		go func() {
+			defer func() {
+				if v := recover(); v != nil {
+					fmt.Fprintf(stderr, "kvs: connection %s: panic: %v\n", conn.RemoteAddr(), v)
+				}
+				c.mu.Lock()
+				delete(c.active, conn)
+				c.mu.Unlock()
+				conn.Close()
+				c.wg.Done()
+			}()
+			handle(conn)
+		}()
+	}
+}
+
+// shutdown stops accepting connections and waits for each connection to
+// finish the request in flight
+func (c *conns) shutdown() {
+	c.listener.Close()
+	c.mu.Lock()
+	for conn := range c.active {
+		conn.SetReadDeadline(time.Now())
+	}
+	c.mu.Unlock()
+	c.wg.Wait()
+}
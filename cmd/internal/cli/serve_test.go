@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/zxdev/kvs"
+)
+
+// go test -v -run PatchApply
+func TestPatchApply(t *testing.T) {
+
+	// a full table updates values in place of the removed key and
+	// reports a new key as NoSpace
+	path := filepath.Join(t.TempDir(), "full.keva")
+	kn := kvs.NewKEVA(10, &kvs.Option{Density: 50})
+	insert := kn.Insert(false)
+	var keys []string
+	for i := 0; kn.Len() < kn.Cap(); i++ {
+		key := string(rune('a'+i%26)) + string(rune('0'+i/26))
+		if insert([]byte(key), 1).Ok {
+			keys = append(keys, key)
+		}
+	}
+	kn.Write(path)
+	s, err := newServer([]string{path}, 0)
+	if err != nil {
+		t.Log("server failure", err)
+		t.FailNow()
+	}
+	defer s.close()
+	h := s.tables["full"]
+
+	var p patch
+	for i, key := range keys {
+		p.Insert = append(p.Insert, struct {
+			Key   string `json:"key"`
+			Value uint64 `json:"value"`
+		}{key, uint64(i + 2)})
+	}
+	p.Insert = append(p.Insert, struct {
+		Key   string `json:"key"`
+		Value uint64 `json:"value"`
+	}{"new", 1})
+	r, err := p.apply(s, h)
+	if err != nil || r.Updated != uint64(len(keys)) || r.NoSpace != 1 || r.Len != kn.Cap() {
+		t.Log("patch failure", err, r)
+		t.FailNow()
+	}
+	for i, key := range keys {
+		if item := h.Lookup([]byte(key)); !item.Ok || item.Value != uint64(i+2) {
+			t.Log("patch value failure", key, item)
+			t.FailNow()
+		}
+	}
+
+}
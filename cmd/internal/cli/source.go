@@ -0,0 +1,415 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// input formats
+const (
+	inputText  = "text"
+	inputCSV   = "csv"
+	inputTSV   = "tsv"
+	inputJSONL = "jsonl"
+)
+
+// source describes how to read key and value records from a builder source
+//
+//	text   keon; each line is a key
+//	       keva; key{delimiter}value
+//	csv    -key and -value column index
+//	tsv    -key and -value column index
+//	jsonl  -key and -value field path; eg. user.id
+//
+// the value is a uint64 encoded as dec, hex, or base64 (big endian) and
+// gzip or zstd compressed sources are decompressed automatically
+type source struct {
+	input, delimiter string
+	key, value       string
+	encoding         string
+	header, strict   bool
+	keva             bool
+}
+
+// sourceFlags adds the source flags to a command
+func sourceFlags(fs *flag.FlagSet) *source {
+	var s = new(source)
+	fs.StringVar(&s.input, "input", inputText, "input format text, csv, tsv, or jsonl")
+	fs.StringVar(&s.delimiter, "delimiter", ",", "text keva key value delimiter")
+	fs.StringVar(&s.key, "key", "", "key column index (csv, tsv) or field path (jsonl); default 0 or key")
+	fs.StringVar(&s.value, "value", "", "value column index (csv, tsv) or field path (jsonl); default 1 or value")
+	fs.StringVar(&s.encoding, "encoding", "dec", "value encoding dec, hex, or base64")
+	fs.BoolVar(&s.header, "header", false, "skip the first csv or tsv record")
+	fs.BoolVar(&s.strict, "strict", false, "fail the build on a malformed line")
+	return s
+}
+
+// check the source settings and apply the defaults
+func (s *source) check() error {
+	switch s.input {
+	case inputText, inputCSV, inputTSV:
+		if len(s.key) == 0 {
+			s.key = "0"
+		}
+		if len(s.value) == 0 {
+			s.value = "1"
+		}
+		if s.input != inputText {
+			for _, v := range []string{s.key, s.value} {
+				if _, err := strconv.Atoi(v); err != nil {
+					return fmt.Errorf("column %q is not an index", v)
+				}
+			}
+		}
+	case inputJSONL:
+		if len(s.key) == 0 {
+			s.key = "key"
+		}
+		if len(s.value) == 0 {
+			s.value = "value"
+		}
+	default:
+		return fmt.Errorf("unknown input %q", s.input)
+	}
+	switch s.encoding {
+	case "dec", "hex", "base64":
+	default:
+		return fmt.Errorf("unknown encoding %q", s.encoding)
+	}
+	return nil
+}
+
+// open the source at path, or stdin with -, and transparently
+// decompress a gzip or zstd source by sensing the magic number
+func open(path string) (io.ReadCloser, error) {
+
+	var rc io.ReadCloser = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		rc = f
+	}
+
+	var br = bufio.NewReaderSize(rc, 64*1024)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		return readCloser{zr, func() error { zr.Close(); return rc.Close() }}, nil
+
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		// zstd is not in the standard library so the zstd tool is used
+		// rather than adding a dependency to the kvs module
+		cmd := exec.Command("zstd", "-dc")
+		cmd.Stdin = br
+		cmd.Stderr = stderr
+		out, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("zstd source requires the zstd tool: %v", err)
+		}
+		// a reader that stops early leaves zstd blocked writing to the pipe
+		// so it is killed, and only a complete read reports the exit status
+		zr := &eofReader{Reader: out}
+		return readCloser{zr, func() error {
+			if !zr.eof {
+				cmd.Process.Kill()
+			}
+			err := cmd.Wait()
+			rc.Close()
+			if !zr.eof {
+				return nil
+			}
+			return err
+		}}, nil
+	}
+
+	return readCloser{br, rc.Close}, nil
+}
+
+// eofReader records that the reader was read to the end
+type eofReader struct {
+	io.Reader
+	eof bool
+}
+
+func (r *eofReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.eof = r.eof || err == io.EOF
+	return n, err
+}
+
+// readCloser pairs a reader with a close function
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (rc readCloser) Close() error { return rc.close() }
+
+// malformed is a malformed source line
+type malformed struct {
+	line int
+	err  error
+}
+
+func (m malformed) Error() string { return fmt.Sprintf("line %d: %v", m.line, m.err) }
+
+// scan reads every record from r and calls fn with the key and value; a
+// malformed record is passed to bad which stops the scan by returning false
+func (s *source) scan(r io.Reader, fn func(key []byte, value uint64) bool, bad func(malformed) bool) error {
+
+	var line int
+	var key []byte
+	var value uint64
+	var err error
+
+	switch s.input {
+	case inputText:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line++
+			if !s.keva {
+				if len(scanner.Bytes()) == 0 {
+					if !bad(malformed{line, errors.New("empty key")}) {
+						return nil
+					}
+					continue
+				}
+				if !fn(scanner.Bytes(), 0) {
+					return nil
+				}
+				continue
+			}
+			seg := strings.Split(scanner.Text(), s.delimiter)
+			if len(seg) != 2 || len(seg[0]) == 0 {
+				err = fmt.Errorf("expected key%svalue", s.delimiter)
+			} else {
+				key = []byte(seg[0])
+				value, err = s.decode(seg[1])
+			}
+			if err != nil {
+				if !bad(malformed{line, err}) {
+					return nil
+				}
+				continue
+			}
+			if !fn(key, value) {
+				return nil
+			}
+		}
+		return scanner.Err()
+
+	case inputCSV, inputTSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.ReuseRecord = true
+		if s.input == inputTSV {
+			cr.Comma = '\t'
+			cr.LazyQuotes = true
+		}
+		k, _ := strconv.Atoi(s.key)
+		v, _ := strconv.Atoi(s.value)
+		for first := true; ; first = false {
+			record, err := cr.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if first && s.header {
+				continue
+			}
+			if err != nil {
+				// the field positions are only valid for a parsed record
+				var perr *csv.ParseError
+				if errors.As(err, &perr) {
+					if !bad(malformed{perr.Line, perr.Err}) {
+						return nil
+					}
+					continue
+				}
+				return err
+			}
+			if len(record) > 0 {
+				line, _ = cr.FieldPos(0)
+			}
+			switch {
+			case k >= len(record) || len(record[k]) == 0:
+				err = fmt.Errorf("missing key column %d", k)
+			case s.keva && v >= len(record):
+				err = fmt.Errorf("missing value column %d", v)
+			case s.keva:
+				value, err = s.decode(record[v])
+			}
+			if err != nil {
+				if !bad(malformed{line, err}) {
+					return nil
+				}
+				continue
+			}
+			if !fn([]byte(record[k]), value) {
+				return nil
+			}
+		}
+
+	case inputJSONL:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line++
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			key, value, err = s.field(scanner.Bytes())
+			if err != nil {
+				if !bad(malformed{line, err}) {
+					return nil
+				}
+				continue
+			}
+			if !fn(key, value) {
+				return nil
+			}
+		}
+		return scanner.Err()
+	}
+
+	return nil
+}
+
+// read every record from the source at path, or stdin with -, and call fn
+// with the key and value; malformed lines are reported with the line number
+// and skipped, or the first malformed line is the error with strict
+func (s *source) read(path string, fn func(key []byte, value uint64)) error {
+
+	r, err := open(path)
+	if err != nil {
+		return err
+	}
+
+	var bad uint64
+	var first malformed
+	err = s.scan(r,
+		func(key []byte, value uint64) bool { fn(key, value); return true },
+		func(m malformed) bool {
+			if bad == 0 {
+				first = m
+			}
+			bad++
+			if !s.strict {
+				fmt.Fprintf(stderr, "kvs: %s: %v\n", path, m)
+			}
+			return !s.strict
+		})
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
+
+	switch {
+	case err != nil:
+		return fmt.Errorf("%s: %v", path, err)
+	case s.strict && bad > 0:
+		return fmt.Errorf("%s: %v", path, first)
+	case bad > 0:
+		fmt.Fprintf(stderr, "kvs: %s: skipped %d malformed lines\n", path, bad)
+	}
+	return nil
+}
+
+// field extracts the key and value from a json line using the field paths
+func (s *source) field(b []byte) (key []byte, value uint64, err error) {
+
+	var doc interface{}
+	var d = json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err = d.Decode(&doc); err != nil {
+		return nil, 0, err
+	}
+
+	lookup := func(path string) (interface{}, bool) {
+		var v = doc
+		for _, name := range strings.Split(path, ".") {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = m[name]; !ok {
+				return nil, false
+			}
+		}
+		return v, v != nil
+	}
+
+	k, ok := lookup(s.key)
+	switch k := k.(type) {
+	case string:
+		key = []byte(k)
+	case json.Number:
+		key = []byte(k.String())
+	}
+	if !ok || len(key) == 0 {
+		return nil, 0, fmt.Errorf("missing key field %q", s.key)
+	}
+
+	if s.keva {
+		v, ok := lookup(s.value)
+		switch v := v.(type) {
+		case string:
+			value, err = s.decode(v)
+		case json.Number:
+			value, err = strconv.ParseUint(v.String(), 10, 64)
+		default:
+			if ok {
+				err = fmt.Errorf("invalid value field %q", s.value)
+			}
+		}
+		if !ok {
+			err = fmt.Errorf("missing value field %q", s.value)
+		}
+	}
+
+	return
+}
+
+// decode the value using the value encoding
+func (s *source) decode(v string) (uint64, error) {
+	v = strings.TrimSpace(v)
+	switch s.encoding {
+	case "hex":
+		return strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(v, "0x"), "0X"), 16, 64)
+	case "base64":
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return 0, err
+		}
+		if len(b) > 8 {
+			return 0, fmt.Errorf("value %q exceeds 8 bytes", v)
+		}
+		var u [8]byte
+		copy(u[8-len(b):], b)
+		return binary.BigEndian.Uint64(u[:]), nil
+	}
+	return strconv.ParseUint(v, 10, 64)
+}
@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// go test -v -run SourceScan
+func TestSourceScan(t *testing.T) {
+
+	for _, tc := range []struct {
+		name   string
+		src    source
+		in     string
+		keys   []string
+		values []uint64
+		bad    []int // malformed line numbers
+	}{
+		{name: "text keon", src: source{input: inputText},
+			in: "a\n\nb\n", keys: []string{"a", "b"}, values: []uint64{0, 0}, bad: []int{2}},
+		{name: "text keva", src: source{input: inputText, delimiter: ",", keva: true},
+			in: "a,1\nb\n,3\nc,x\nd,4\n", keys: []string{"a", "d"}, values: []uint64{1, 4}, bad: []int{2, 3, 4}},
+		{name: "text hex", src: source{input: inputText, delimiter: "=", keva: true, encoding: "hex"},
+			in: "a=ff\nb=0x10\nc=zz\n", keys: []string{"a", "b"}, values: []uint64{255, 16}, bad: []int{3}},
+		{name: "text base64", src: source{input: inputText, delimiter: " ", keva: true, encoding: "base64"},
+			in: "a AQI=\nb AQIDBAUGBwgJ\nc !!\n", keys: []string{"a"}, values: []uint64{258}, bad: []int{2, 3}},
+		{name: "csv keva", src: source{input: inputCSV, keva: true},
+			in: "k1,1\nk2,2,extra\n,3\nk4\nk5,5\n", keys: []string{"k1", "k2", "k5"}, values: []uint64{1, 2, 5}, bad: []int{3, 4}},
+		{name: "csv header", src: source{input: inputCSV, keva: true, header: true, key: "1", value: "0"},
+			in: "value,key\n7,a\n\"8\",\"b,c\"\n", keys: []string{"a", "b,c"}, values: []uint64{7, 8}},
+		{name: "csv bad quote first field", src: source{input: inputCSV, keva: true},
+			in: "k1,1\na\"b,1\nk3,3\n", keys: []string{"k1", "k3"}, values: []uint64{1, 3}, bad: []int{2}},
+		{name: "csv bad quote later field", src: source{input: inputCSV, keva: true},
+			in: "k1,1\nk2,\"2\n", keys: []string{"k1"}, values: []uint64{1}, bad: []int{2}},
+		{name: "csv bad header", src: source{input: inputCSV, header: true},
+			in: "a\"b\nk1\n", keys: []string{"k1"}, values: []uint64{0}},
+		{name: "tsv keon", src: source{input: inputTSV, key: "1"},
+			in: "1\ta\"b\n2\n3\tc\n", keys: []string{"a\"b", "c"}, values: []uint64{0, 0}, bad: []int{2}},
+		{name: "jsonl keva", src: source{input: inputJSONL, key: "user.id", value: "n", keva: true},
+			in:   "{\"user\":{\"id\":\"a\"},\"n\":1}\n\n{\"user\":{\"id\":2},\"n\":\"3\"}\n{\"user\":{}}\n{bad\n{\"user\":{\"id\":\"c\"},\"n\":true}\n{\"user\":{\"id\":\"d\"},\"n\":-1}\n",
+			keys: []string{"a", "2"}, values: []uint64{1, 3}, bad: []int{4, 5, 6, 7}},
+		{name: "jsonl keon", src: source{input: inputJSONL},
+			in: "{\"key\":\"a\"}\n{\"key\":null}\n{\"key\":\"\"}\n[1]\n", keys: []string{"a"}, values: []uint64{0}, bad: []int{2, 3, 4}},
+	} {
+		if len(tc.src.encoding) == 0 {
+			tc.src.encoding = "dec"
+		}
+		if err := tc.src.check(); err != nil {
+			t.Log(tc.name, "check failure", err)
+			t.FailNow()
+		}
+		var keys []string
+		var values []uint64
+		var bad []int
+		err := tc.src.scan(strings.NewReader(tc.in),
+			func(key []byte, value uint64) bool {
+				keys, values = append(keys, string(key)), append(values, value)
+				return true
+			},
+			func(m malformed) bool { bad = append(bad, m.line); return true })
+		if err != nil || !reflect.DeepEqual(keys, tc.keys) || !reflect.DeepEqual(values, tc.values) || !reflect.DeepEqual(bad, tc.bad) {
+			t.Log(tc.name, "scan failure", err, keys, values, bad)
+			t.Fail()
+		}
+	}
+
+	// a bad callback returning false stops the scan
+	var s = source{input: inputCSV, keva: true, encoding: "dec"}
+	s.check()
+	var n int
+	s.scan(strings.NewReader("a\"b,1\nk2,2\n"), func([]byte, uint64) bool { n++; return true }, func(malformed) bool { return false })
+	if n != 0 {
+		t.Log("scan continued after a stop", n)
+		t.Fail()
+	}
+
+}
+
+// go test -v -run SourceCheck
+func TestSourceCheck(t *testing.T) {
+
+	for _, tc := range []struct {
+		src source
+		ok  bool
+	}{
+		{source{input: inputText, encoding: "dec"}, true},
+		{source{input: inputCSV, key: "2", encoding: "hex"}, true},
+		{source{input: inputCSV, key: "id", encoding: "dec"}, false},
+		{source{input: inputJSONL, key: "a.b", encoding: "base64"}, true},
+		{source{input: "xml", encoding: "dec"}, false},
+		{source{input: inputText, encoding: "oct"}, false},
+	} {
+		if err := tc.src.check(); (err == nil) != tc.ok {
+			t.Log(tc.src, "check failure", err)
+			t.Fail()
+		}
+	}
+
+}
+
+// go test -v -run SourceRead
+func TestSourceRead(t *testing.T) {
+
+	stderr = io.Discard
+	defer func() { stderr = os.Stderr }()
+
+	// a gzip source is decompressed by sensing the magic number
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte("a,1\nb,x\nc,3\n"))
+	zw.Close()
+	path := filepath.Join(t.TempDir(), "src.gz")
+	os.WriteFile(path, b.Bytes(), 0644)
+
+	var s = source{input: inputText, delimiter: ",", keva: true, encoding: "dec"}
+	s.check()
+	var sum uint64
+	if err := s.read(path, func(_ []byte, v uint64) { sum += v }); err != nil || sum != 4 {
+		t.Log("gzip read failure", err, sum)
+		t.FailNow()
+	}
+
+	// strict fails on the first malformed line
+	s.strict = true
+	if err := s.read(path, func([]byte, uint64) {}); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Log("strict read failure", err)
+		t.FailNow()
+	}
+
+	// a truncated gzip source is an error
+	os.WriteFile(path, b.Bytes()[:b.Len()-6], 0644)
+	s.strict = false
+	if err := s.read(path, func([]byte, uint64) {}); err == nil {
+		t.Log("truncated gzip accepted")
+		t.FailNow()
+	}
+
+	// a zstd source that is not read to the end does not block the close
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Log("zstd tool not found")
+		return
+	}
+	path = filepath.Join(t.TempDir(), "src")
+	os.WriteFile(path, []byte("a,1\nb,x\n"+strings.Repeat("c,3\n", 1<<18)), 0644)
+	if err := exec.Command("zstd", "-q", path).Run(); err != nil {
+		t.Log("zstd failure", err)
+		t.FailNow()
+	}
+	s.strict = true
+	var done = make(chan error, 1)
+	go func() { done <- s.read(path+".zst", func([]byte, uint64) {}) }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Log("zstd strict read failure", err)
+			t.FailNow()
+		}
+	case <-time.After(10 * time.Second):
+		t.Log("zstd close blocked")
+		t.FailNow()
+	}
+	s.strict = false
+	sum = 0
+	if err := s.read(path+".zst", func(_ []byte, v uint64) { sum += v }); err != nil || sum != 1+3<<18 {
+		t.Log("zstd read failure", err, sum)
+		t.FailNow()
+	}
+
+}
@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+// analysis is the kvs Analyze bucket occupancy and load report
+type analysis = struct {
+	Count, Max, Slots uint64
+	Depth, Width      uint64
+	Fill              []uint64
+	Row               [3]uint64
+	Misplaced         uint64
+	FullRun           uint64
+	ProbeHit          float64
+	ProbeMiss         float64
+	Headroom          uint64
+}
+
+// stats reports the bucket fill distribution, the candidate row placement
+// of the keys, the longest run of full rows, the expected lookup probes,
+// and the estimated insert headroom of a kvs file
+//
+//	kvs stats [flags] {file}
+func stats(args []string) int {
+
+	fs := flags("stats")
+	asJSON := fs.Bool("json", false, "json output")
+	if code, ok := parse(fs, args, 1); !ok {
+		return code
+	}
+
+	t, err := load(fs.Arg(0))
+	if err != nil {
+		return failure("%v", err)
+	}
+	var a analysis
+	if t.keon != nil {
+		a = t.keon.Analyze()
+	} else {
+		a = t.keva.Analyze()
+	}
+
+	if *asJSON {
+		json.NewEncoder(stdout).Encode(struct {
+			Path      string    `json:"path"`
+			Count     uint64    `json:"count"`
+			Max       uint64    `json:"max"`
+			Slots     uint64    `json:"slots"`
+			Depth     uint64    `json:"depth"`
+			Width     uint64    `json:"width"`
+			Fill      []uint64  `json:"fill"`
+			Row       [3]uint64 `json:"row"`
+			Misplaced uint64    `json:"misplaced"`
+			FullRun   uint64    `json:"full_run"`
+			ProbeHit  float64   `json:"probe_hit"`
+			ProbeMiss float64   `json:"probe_miss"`
+			Headroom  uint64    `json:"headroom"`
+		}{t.path, a.Count, a.Max, a.Slots, a.Depth, a.Width, a.Fill, a.Row, a.Misplaced, a.FullRun,
+			a.ProbeHit, a.ProbeMiss, a.Headroom})
+		return exitOk
+	}
+
+	var percent = func(n, of uint64) float64 {
+		if of == 0 {
+			return 0
+		}
+		return float64(n) * 100 / float64(of)
+	}
+	fmt.Fprintln(stdout, "\n ", filepath.Base(t.path))
+	fmt.Fprintln(stdout, "---------------------------------")
+	fmt.Fprintf(stdout, "keys       : %d of %d max in %d slots, %d x %d\n", a.Count, a.Max, a.Slots, a.Depth, a.Width)
+	for used, rows := range a.Fill {
+		fmt.Fprintf(stdout, "  fill %-4d: %d rows %.2f%%\n", used, rows, percent(rows, a.Depth))
+	}
+	for i, keys := range a.Row {
+		fmt.Fprintf(stdout, "  row %-5d: %d keys %.2f%%\n", i+1, keys, percent(keys, a.Count))
+	}
+	fmt.Fprintln(stdout, "misplaced  :", a.Misplaced)
+	fmt.Fprintln(stdout, "full run   :", a.FullRun, "rows")
+	fmt.Fprintf(stdout, "probes     : %.2f hit %.0f miss\n", a.ProbeHit, a.ProbeMiss)
+	fmt.Fprintf(stdout, "headroom   : %d inserts of %d free\n", a.Headroom, a.Max-a.Count)
+	fmt.Fprintln(stdout)
+	return exitOk
+}
@@ -0,0 +1,202 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/zxdev/kvs"
+)
+
+// tune searches the option space with the keys of a source, or a sample of
+// the first keys, and reports the build and lookup throughput and memory of
+// each candidate and the recommended kvs build flags for the goal; with -out
+// the table is built from the full source with the recommended option
+//
+//	kvs tune [flags] {source}
+func tune(args []string) int {
+
+	fs := flags("tune")
+	format := formats(fs)
+	kind := fs.String("type", "", "kvs type keon or keva; default from -out extension or keon")
+	out := fs.String("out", "", "build the table with the recommended option")
+	goal := fs.String("goal", "memory", "tuning goal memory, build, lookup, or fill")
+	fill := fs.Float64("fill", 0.999, "fill goal target fill ratio")
+	sample := fs.Int("sample", 0, "tune with the first n keys; 0 all")
+	src := sourceFlags(fs)
+	if code, ok := parse(fs, args, 1); !ok {
+		return code
+	}
+	f, ok := format()
+	if !ok {
+		return exitUsage
+	}
+
+	var g = kvs.Goal{Fill: *fill}
+	switch *goal {
+	case "memory":
+		g.Target = kvs.TuneMemory
+	case "build":
+		g.Target = kvs.TuneBuild
+	case "lookup":
+		g.Target = kvs.TuneLookup
+	case "fill":
+		g.Target = kvs.TuneFill
+	default:
+		fmt.Fprintf(stderr, "kvs: unknown goal %q\n", *goal)
+		return exitUsage
+	}
+
+	source := fs.Arg(0)
+	if len(*kind) == 0 {
+		*kind = "keon"
+		if filepath.Ext(*out) == ".keva" {
+			*kind = "keva"
+		}
+	}
+	switch *kind {
+	case "keon", "keva":
+		src.keva = *kind == "keva"
+	default:
+		return failure("unknown type %q", *kind)
+	}
+	if err := src.check(); err != nil {
+		fmt.Fprintf(stderr, "kvs: %v\n", err)
+		return exitUsage
+	}
+
+	// the keys are held for tuning and the full source is staged for -out
+	var keys [][]byte
+	var stageKEON *kvs.StageKEON
+	var stageKEVA *kvs.StageKEVA
+	if len(*out) > 0 {
+		if src.keva {
+			stageKEVA = kvs.NewStageKEVA(0)
+			defer stageKEVA.Close()
+		} else {
+			stageKEON = kvs.NewStageKEON(0)
+			defer stageKEON.Close()
+		}
+	}
+	var staged uint64
+	err := src.read(source, func(key []byte, value uint64) {
+		staged++
+		if *sample == 0 || len(keys) < *sample {
+			keys = append(keys, append([]byte(nil), key...))
+		}
+		switch {
+		case stageKEON != nil:
+			stageKEON.Add(key)
+		case stageKEVA != nil:
+			stageKEVA.Add(key, value)
+		}
+	})
+	if err != nil {
+		return failure("%v", err)
+	}
+	if len(keys) == 0 {
+		return failure("empty source %s", source)
+	}
+
+	result := kvs.AutoTune(keys, g)
+
+	var records []tuneRecord
+	for _, trial := range result.Trials {
+		if src.keva {
+			trial.Memory *= 2 // values
+		}
+		records = append(records, tuneRecord{
+			Density:     trial.Option.Density,
+			Width:       trial.Option.Width,
+			Shuffler:    trial.Option.Shuffler,
+			Tracker:     trial.Option.Tracker,
+			Ok:          trial.Ok,
+			Fill:        trial.Fill,
+			Memory:      trial.Memory,
+			Build:       uint64(trial.Build),
+			Lookup:      uint64(trial.Lookup),
+			Recommended: result.Ok && trial.Ok && trial.Option == result.Option,
+		})
+	}
+
+	switch f {
+	case formatJSON:
+		enc := json.NewEncoder(stdout)
+		for _, r := range records {
+			enc.Encode(r)
+		}
+	case formatTSV:
+		fmt.Fprintln(stdout, "density\twidth\tshuffler\ttracker\tok\tfill\tmemory\tbuild\tlookup\trecommended")
+		for _, r := range records {
+			fmt.Fprintf(stdout, "%d\t%d\t%d\t%d\t%v\t%s\t%d\t%d\t%d\t%v\n", r.Density, r.Width, r.Shuffler, r.Tracker,
+				r.Ok, strconv.FormatFloat(r.Fill, 'f', 6, 64), r.Memory, r.Build, r.Lookup, r.Recommended)
+		}
+	default:
+		fmt.Fprintln(stdout, "\n ", filepath.Base(source), len(keys), "keys", *goal)
+		fmt.Fprintln(stdout, "-------------------------------------------------------------------------")
+		fmt.Fprintf(stdout, "%8s %6s %9s %8s %8s %12s %10s %10s\n", "density", "width", "shuffler", "tracker", "fill", "memory", "build/s", "lookup/s")
+		for _, r := range records {
+			var mark string
+			if r.Recommended {
+				mark = " *"
+			}
+			if !r.Ok {
+				fmt.Fprintf(stdout, "%8d %6d %9d %8d %8s\n", r.Density, r.Width, r.Shuffler, r.Tracker, "nospace")
+				continue
+			}
+			fmt.Fprintf(stdout, "%8d %6d %9d %8d %7.3f%% %12d %10d %10d%s\n", r.Density, r.Width, r.Shuffler, r.Tracker,
+				r.Fill*100, r.Memory, r.Build, r.Lookup, mark)
+		}
+		if result.Ok {
+			fmt.Fprintf(stdout, "\nrecommend  : kvs build -density %d -width %d -shuffler %d -tracker %d\n\n",
+				result.Option.Density, result.Option.Width, result.Option.Shuffler, result.Option.Tracker)
+		}
+	}
+
+	if !result.Ok {
+		return failure("no candidate option for %s", source)
+	}
+	if len(*out) == 0 {
+		return exitOk
+	}
+
+	// the tuned table is the table when it was tuned with every key
+	var opt = result.Option
+	switch {
+	case stageKEON != nil && uint64(len(keys)) == staged:
+		err = result.KEON.Write(*out)
+	case stageKEON != nil:
+		var kn *kvs.KEON
+		if kn, err = stageKEON.Build(&opt); err == nil {
+			err = kn.Write(*out)
+		}
+	case stageKEVA != nil:
+		var kv *kvs.KEVA
+		if kv, err = stageKEVA.Build(&opt); err == nil {
+			err = kv.Write(*out)
+		}
+	}
+	switch {
+	case errors.Is(err, kvs.ErrNoSpace):
+		return failure("count[%d] density[%d], width[%d]; tune with a larger sample", staged, opt.Density, opt.Width)
+	case err != nil:
+		return failure("%v", err)
+	}
+	return exitOk
+}
+
+// tuneRecord is the machine-readable tune candidate
+type tuneRecord struct {
+	Density     uint64  `json:"density"`
+	Width       uint64  `json:"width"`
+	Shuffler    uint64  `json:"shuffler"`
+	Tracker     int     `json:"tracker"`
+	Ok          bool    `json:"ok"`
+	Fill        float64 `json:"fill"`
+	Memory      uint64  `json:"memory"`
+	Build       uint64  `json:"build"`
+	Lookup      uint64  `json:"lookup"`
+	Recommended bool    `json:"recommended"`
+}
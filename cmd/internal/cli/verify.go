@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/zxdev/kvs"
+)
+
+// verify performs a deep integrity check of a kvs file
+//
+//	kvs verify [flags] {file}
+func verify(args []string) int {
+
+	fs := flags("verify")
+	asJSON := fs.Bool("json", false, "json output")
+	if code, ok := parse(fs, args, 1); !ok {
+		return code
+	}
+
+	path, err := resolve(fs.Arg(0))
+	if err != nil {
+		return failure("%v", err)
+	}
+	r := kvs.Verify(path)
+
+	if *asJSON {
+		json.NewEncoder(stdout).Encode(struct {
+			Path      string `json:"path"`
+			Ok        bool   `json:"ok"`
+			Header    bool   `json:"header"`
+			Signature string `json:"signature"`
+			Size      int64  `json:"size"`
+			Expect    int64  `json:"expect"`
+			Checksum  uint64 `json:"checksum"`
+			Computed  uint64 `json:"computed"`
+			Count     uint64 `json:"count"`
+			Slots     uint64 `json:"slots"`
+			Misplaced uint64 `json:"misplaced"`
+			Gaps      uint64 `json:"gaps"`
+			Orphans   uint64 `json:"orphans"`
+		}{path, r.Ok, r.Header, kind(r.Signature), r.Size, r.Expect, r.Checksum, r.Computed,
+			r.Count, r.Slots, r.Misplaced, r.Gaps, r.Orphans})
+	} else {
+		status := func(ok bool) string {
+			if ok {
+				return "ok  "
+			}
+			return "FAIL"
+		}
+		fmt.Fprintln(stdout, "\n ", filepath.Base(path))
+		fmt.Fprintln(stdout, "---------------------------------")
+		fmt.Fprintln(stdout, "header     :", status(r.Header), kind(r.Signature), r.Depth, "x", r.Width)
+		fmt.Fprintln(stdout, "size       :", status(r.Size == r.Expect), r.Size, r.Expect)
+		fmt.Fprintln(stdout, "checksum   :", status(r.Checksum == r.Computed), r.Checksum, r.Computed)
+		fmt.Fprintln(stdout, "count      :", status(r.Count == r.Slots), r.Count, r.Slots)
+		fmt.Fprintln(stdout, "placement  :", status(r.Misplaced == 0), r.Misplaced, "misplaced")
+		fmt.Fprintln(stdout, "buckets    :", status(r.Gaps == 0), r.Gaps, "gaps")
+		if r.Signature == 0xff02 {
+			fmt.Fprintln(stdout, "values     :", status(r.Orphans == 0), r.Orphans, "orphans")
+		}
+		fmt.Fprintln(stdout, "verify     :", status(r.Ok))
+		fmt.Fprintln(stdout)
+	}
+
+	if !r.Ok {
+		return exitFailure
+	}
+	return exitOk
+}
@@ -1,61 +1,15 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
 	"os"
-	"strconv"
 
-	"github.com/zxdev/kvs"
+	"github.com/zxdev/kvs/cmd/internal/cli"
 )
 
 // kvs-keon
 //	build a keon from an \n list source
+//	alias of kvs build -type keon
 
 func main() {
-
-	switch len(os.Args) {
-	case 1:
-		fmt.Println("DENSITY={n} WIDTH={n} kvs {file}")
-		return
-
-	case 2:
-
-		density, _ := strconv.Atoi(os.Getenv("DENSITY"))
-		width, _ := strconv.Atoi(os.Getenv("WIDTH"))
-
-		if density == 0 {
-			density += 5
-		}
-		if width == 0 {
-			width += 3
-		}
-
-		f, err := os.Open(os.Args[1])
-		if err == nil {
-			defer f.Close()
-
-			var count uint64
-			scanner := bufio.NewScanner(f)
-			for scanner.Scan() {
-				count++
-			}
-
-			f.Seek(0, 0) // rewind
-
-			kv := kvs.NewKEON(count, &kvs.Option{Density: uint64(density), Width: uint64(width)})
-			insert := kv.Insert(false)
-			scanner = bufio.NewScanner(f)
-			for scanner.Scan() {
-				if insert(scanner.Bytes()).NoSpace {
-					fmt.Printf("failure: count[%d] density[%d], width[%d]", count, density, width)
-					return
-				}
-			}
-
-			kv.Write(os.Args[1] + ".keon")
-		}
-
-	}
-
+	os.Exit(cli.Alias("kvs-keon", os.Args[1:]))
 }
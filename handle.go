@@ -0,0 +1,355 @@
+package kvs
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zxdev/xxhash"
+)
+
+/*
+	HANDLE holds the current *KEON or *KEVA table for a long-running
+	service behind an atomic pointer; a new version of the table file
+	is loaded and validated in the background and swapped in without
+	blocking readers, so readers always see a complete table.
+
+	h, err := kvs.NewHandle(path)
+	h.OnReload = func(info) { ... }
+	h.OnReject = func(info, err) { ... }
+	h.Watch(time.Minute) // or h.Reload() when signaled
+	defer h.Close()
+	...
+	if h.Lookup(key).Ok { ... } // safe for concurrent readers
+
+	readers never take a lock; an Update modifies a copy of the table
+	that is swapped in when fn returns without an error, discarded on an
+	error, so the table memory is doubled
+	for the duration of an Update and modifications are best batched,
+	and Save writes the table to the path without triggering a reload
+
+	h.Update(func(keon *KEON, keva *KEVA) error { ... })
+	h.Save()
+
+*/
+
+// header is the Info file header information
+type header = struct {
+	Signature, Checksum, Timestamp, Count, Max uint64 // externals
+	Depth, Width, Density, Shuffler, Tracker   uint64 // internals
+	Ok                                         bool   // status
+}
+
+// version is a loaded table and the header it was loaded from; a
+// version is never modified once it is current
+type version struct {
+	info       header
+	keon       *KEON
+	keva       *KEVA
+	load       time.Duration // load duration
+	generation uint64        // load of the table; kept by an Update
+	name       string        // metrics table name
+	metrics    Metrics       // optional metrics hook
+}
+
+// Handle is a hot-reloading table handle that is safe for concurrent readers
+type Handle struct {
+	path    string                  // path to file
+	current atomic.Pointer[version] // current table
+	reload  sync.Mutex              // serialize reloads and updates
+	loads   uint64                  // versions loaded
+	reject  [4]int64                // last rejected timestamp, checksum, size, mtime
+	stop    chan struct{}           // stop watcher
+	wg      sync.WaitGroup          // watcher
+	name    string                  // metrics table name
+	metrics Metrics                 // optional metrics hook
+
+	// OnReload and OnReject are optional callbacks that are called
+	// after a new version is swapped in or when it fails validation
+	OnReload func(info header)
+	OnReject func(info header, err error)
+}
+
+// NewHandle is the *Handle constructor that loads the initial table at path.
+func NewHandle(path string) (*Handle, error) {
+	var h = &Handle{path: path}
+	v, err := h.load(Info(path))
+	if err != nil {
+		return nil, err
+	}
+	h.current.Store(v)
+	return h, nil
+}
+
+// load and validate the table version described by info
+func (h *Handle) load(info header) (*version, error) {
+
+	if !info.Ok {
+		return nil, errors.New("kvs: invalid resource " + h.path)
+	}
+
+	h.loads++
+	var v = &version{info: info, generation: h.loads, name: h.name, metrics: h.metrics}
+	var ok bool
+	var start = time.Now()
+	switch info.Signature {
+	case 0xff01:
+		v.keon, ok = LoadKEON(h.path)
+		ok = ok && v.keon.Checksum() == info.Checksum
+	case 0xff02:
+		v.keva, ok = LoadKEVA(h.path)
+		ok = ok && v.keva.Checksum() == info.Checksum
+	default:
+		return nil, errors.New("kvs: unknown signature " + h.path)
+	}
+	v.load = time.Since(start)
+	if h.metrics != nil {
+		h.metrics.Load(h.name, v.load, ok)
+	}
+	if !ok {
+		return nil, errors.New("kvs: checksum failure " + h.path)
+	}
+	if v.keon != nil {
+		v.keon.SetMetrics(h.name, h.metrics)
+	} else {
+		v.keva.SetMetrics(h.name, h.metrics)
+	}
+
+	return v, nil
+}
+
+// Reload loads, validates, and swaps in the current table file when it
+// differs from the current version; the current version is retained when
+// the new version fails validation.
+func (h *Handle) Reload() error { return h.swap(true) }
+
+// swap in the table file when it has changed; a rejected version is only
+// retried when signaled by a Reload or when the file size or modification
+// time changes, as when a file that was still being copied is complete
+func (h *Handle) swap(retry bool) error {
+
+	h.reload.Lock()
+	defer h.reload.Unlock()
+
+	info := Info(h.path)
+	current := h.current.Load().info
+	var reject = [4]int64{int64(info.Timestamp), int64(info.Checksum)}
+	if fi, err := os.Stat(h.path); err == nil {
+		reject[2], reject[3] = fi.Size(), fi.ModTime().UnixNano()
+	}
+	switch {
+	case info.Ok && info.Timestamp == current.Timestamp && info.Checksum == current.Checksum:
+		return nil // unchanged
+	case !retry && reject == h.reject:
+		return nil // rejected
+	}
+
+	v, err := h.load(info)
+	if err != nil {
+		h.reject = reject
+		if h.OnReject != nil {
+			h.OnReject(info, err)
+		}
+		return err
+	}
+
+	h.current.Store(v)
+	if h.OnReload != nil {
+		h.OnReload(info)
+	}
+	return nil
+}
+
+// replace the current table with the table file unconditionally; the
+// caller holds the reload lock
+func (h *Handle) replace() error {
+	info := Info(h.path)
+	v, err := h.load(info)
+	if err != nil {
+		if h.OnReject != nil {
+			h.OnReject(info, err)
+		}
+		return err
+	}
+	h.current.Store(v)
+	if h.OnReload != nil {
+		h.OnReload(info)
+	}
+	return nil
+}
+
+// Update calls fn with a copy of the current table, either keon or keva, that
+// is modified with the usual closures and swapped in when fn returns nil; an
+// error from fn discards the copy and leaves the table unchanged. Readers see
+// the prior table until then, and the modifications are lost on a reload
+// unless the table is saved.
+func (h *Handle) Update(fn func(keon *KEON, keva *KEVA) error) error {
+	h.reload.Lock()
+	defer h.reload.Unlock()
+	v := h.current.Load()
+	var next = *v
+	if v.keon != nil {
+		next.keon = v.keon.clone()
+	} else {
+		next.keva = v.keva.clone()
+	}
+	if err := fn(next.keon, next.keva); err != nil {
+		return err
+	}
+	h.current.Store(&next)
+	return nil
+}
+
+// snapshot is a copy of the current table, either keon or keva, without the
+// write-ahead log or metrics hook; the copy shares the slots, which is safe
+// since an Update modifies a copy rather than the current table.
+func (h *Handle) snapshot() (*KEON, *KEVA) {
+	h.reload.Lock()
+	defer h.reload.Unlock()
+	v := h.current.Load()
+	if v.keon != nil {
+		var c = *v.keon
+		c.wal, c.metrics = nil, nil
+		return &c, nil
+	}
+	var c = *v.keva
+	c.wal, c.metrics = nil, nil
+	return nil, &c
+}
+
+// generation is the load of the current table, which an Update keeps
+func (h *Handle) generation() uint64 { return h.current.Load().generation }
+
+// Save the current table to the path and adopt the new file header so
+// that the watcher does not reload the saved table.
+func (h *Handle) Save() error {
+	h.reload.Lock()
+	defer h.reload.Unlock()
+	v := h.current.Load()
+	var err error
+	if v.keon != nil {
+		err = v.keon.Write(h.path)
+	} else {
+		err = v.keva.Write(h.path)
+	}
+	if err != nil {
+		return err
+	}
+	var next = *v
+	next.info = Info(h.path)
+	h.current.Store(&next)
+	return nil
+}
+
+// SetMetrics reports the table events as table to m, or stops reporting
+// when m is nil; the load of the current table is reported at once and
+// the tables of later reloads report to m as well.
+func (h *Handle) SetMetrics(table string, m Metrics) {
+	h.reload.Lock()
+	defer h.reload.Unlock()
+	h.name, h.metrics = table, m
+	// the handle readers report through the version hook rather than
+	// the table hook, which only the Update closures read
+	var next = *h.current.Load()
+	next.name, next.metrics = table, m
+	if next.keon != nil {
+		next.keon.SetMetrics(table, m)
+	} else {
+		next.keva.SetMetrics(table, m)
+	}
+	h.current.Store(&next)
+	if m != nil {
+		m.Load(table, next.load, true)
+	}
+}
+
+// Watch polls the table file header every duration in the background and
+// reloads when the timestamp or checksum changes; a rejected version is
+// not retried until the file header, size, or modification time changes.
+func (h *Handle) Watch(every time.Duration) {
+
+	h.reload.Lock()
+	defer h.reload.Unlock()
+	if h.stop != nil {
+		return // already watching
+	}
+	h.stop = make(chan struct{})
+
+	h.wg.Add(1)
+	go func(stop chan struct{}) {
+		defer h.wg.Done()
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				h.swap(false)
+			}
+		}
+	}(h.stop)
+}
+
+// Close stops the watcher.
+func (h *Handle) Close() {
+	h.reload.Lock()
+	stop := h.stop
+	h.stop = nil
+	h.reload.Unlock()
+	if stop != nil {
+		close(stop)
+		h.wg.Wait()
+	}
+}
+
+/*
+	HANDLE reader methods
+		Lookup, Len, Info, KEON, KEVA
+
+*/
+
+// Lookup key in the current table; the Value is always 0 for a *KEON.
+func (h *Handle) Lookup(key []byte) (item struct {
+	Value uint64
+	Ok    bool
+}) {
+	return h.RawLookup(xxhash.Sum(key))
+}
+
+// RawLookup the key hash in the current table; the Value is always 0 for a *KEON.
+func (h *Handle) RawLookup(hash uint64) (item struct {
+	Value uint64
+	Ok    bool
+}) {
+	v := h.current.Load()
+	if v.keon != nil {
+		item.Ok = v.keon.find(hash)
+	} else {
+		item.Value, item.Ok = v.keva.find(hash)
+	}
+	if v.metrics != nil {
+		v.metrics.Lookup(v.name, item.Ok)
+	}
+	return
+}
+
+// Len is the current table count including updates.
+func (h *Handle) Len() uint64 {
+	v := h.current.Load()
+	if v.keon != nil {
+		return v.keon.Len()
+	}
+	return v.keva.Len()
+}
+
+// Info is the file header information of the current table.
+func (h *Handle) Info() header { return h.current.Load().info }
+
+// KEON is the current *KEON table or nil; read only, an Update swaps in a copy.
+func (h *Handle) KEON() *KEON { return h.current.Load().keon }
+
+// KEVA is the current *KEVA table or nil; read only, an Update swaps in a copy.
+func (h *Handle) KEVA() *KEVA { return h.current.Load().keva }
@@ -0,0 +1,178 @@
+package kvs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/zxdev/xxhash"
+)
+
+/*
+	INSPECT renders the internal bucket layout of a *KEON or *KEVA for
+	debugging placement at high density; the summary and row occupancy
+	histogram are always written.
+
+	kn.Inspect(os.Stdout, &kvs.InspectOption{Grid: true, From: 10, To: 20})
+	kn.Inspect(os.Stdout, &kvs.InspectOption{Key: []byte("key")})
+
+*/
+
+// InspectOption selects the Inspect sections
+type InspectOption struct {
+	From, To  uint64 // row range [From,To) for Grid and Occupancy; To 0 is the last row
+	Grid      bool   // render the bucket grid
+	Occupancy bool   // render the per row occupancy
+	Key       []byte // render the candidate rows for the key
+	Raw       bool   // Key is the raw [8]byte big endian hash
+}
+
+// Inspect writes the *KEON layout to w.
+func (kn *KEON) Inspect(w io.Writer, opt *InspectOption) error {
+	return inspect(w, opt, "keon", kn.key, nil, kn.depth, kn.width, kn.count, kn.max, kn.calculate)
+}
+
+// Inspect writes the *KEVA layout to w.
+func (kn *KEVA) Inspect(w io.Writer, opt *InspectOption) error {
+	return inspect(w, opt, "keva", kn.key, kn.value, kn.depth, kn.width, kn.count, kn.max, kn.calculate)
+}
+
+// Dump and format the kn.key
+func (kn *KEON) Dump() {
+	for i := 0; i < len(kn.key); i++ {
+		fmt.Printf("%016x ", kn.key[i])
+		if (i+1)%int(kn.width) == 0 {
+			fmt.Println()
+		}
+	}
+}
+
+// Dump and format the kn.key:kn.value
+func (kn *KEVA) Dump() {
+	for i := 0; i < len(kn.key); i++ {
+		fmt.Printf("%016x:%016x ", kn.key[i], kn.value[i])
+		if (i+1)%int(kn.width) == 0 {
+			fmt.Println()
+		}
+	}
+}
+
+// inspect renders the layout shared by *KEON and *KEVA; value is nil for a *KEON
+func inspect(w io.Writer, opt *InspectOption, kind string, key, value []uint64,
+	depth, width, count, max uint64, calculate func(*[4]uint64)) error {
+
+	if opt == nil {
+		opt = new(InspectOption)
+	}
+	if w == nil {
+		w = os.Stdout
+	}
+	var bw = bufio.NewWriter(w)
+
+	var from, to = opt.From, opt.To
+	if to == 0 || to > depth {
+		to = depth
+	}
+	if from > to {
+		from = to
+	}
+
+	// slot renders a bucket slot
+	slot := func(n uint64) {
+		if value != nil {
+			fmt.Fprintf(bw, " %016x:%016x", key[n], value[n])
+			return
+		}
+		fmt.Fprintf(bw, " %016x", key[n])
+	}
+
+	// used is the number of occupied slots in the row
+	used := func(row uint64) (n uint64) {
+		for j := uint64(0); j < width; j++ {
+			if key[row*width+j] != 0 {
+				n++
+			}
+		}
+		return
+	}
+
+	// summary and occupancy histogram of rows with 0..width items
+	var fill float64
+	if len(key) > 0 {
+		fill = float64(count) * 100 / float64(len(key))
+	}
+	fmt.Fprintf(bw, "%s %d x %d count %d max %d fill %.3f%%\n", kind, depth, width, count, max, fill)
+	var histogram = make([]uint64, width+1)
+	for row := uint64(0); row < depth; row++ {
+		histogram[used(row)]++
+	}
+	fmt.Fprint(bw, "occupancy")
+	for n, rows := range histogram {
+		fmt.Fprintf(bw, " %d:%d", n, rows)
+	}
+	fmt.Fprintln(bw)
+
+	if opt.Occupancy {
+		fmt.Fprintf(bw, "\nrows %d-%d occupancy\n", from, to)
+		for row := from; row < to; row++ {
+			fmt.Fprintf(bw, "%10d %d/%d\n", row, used(row), width)
+		}
+	}
+
+	if opt.Grid {
+		fmt.Fprintf(bw, "\nrows %d-%d grid\n", from, to)
+		for row := from; row < to; row++ {
+			fmt.Fprintf(bw, "%10d", row)
+			for j := uint64(0); j < width; j++ {
+				slot(row*width + j)
+			}
+			fmt.Fprintln(bw)
+		}
+	}
+
+	if opt.Key != nil {
+		var idx [4]uint64
+		if opt.Raw {
+			if len(opt.Key) != 8 {
+				return fmt.Errorf("kvs: raw key must be 8 bytes")
+			}
+			for _, b := range opt.Key {
+				idx[3] = idx[3]<<8 | uint64(b)
+			}
+		} else {
+			idx[3] = xxhash.Sum(opt.Key)
+		}
+		calculate(&idx)
+
+		fmt.Fprintf(bw, "\nkey %016x candidate rows\n", idx[3])
+		var found = -1
+		var empty uint64
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(bw, "%10d", idx[i]/width)
+			for j := uint64(0); j < width; j++ {
+				n := idx[i] + j
+				slot(n)
+				switch {
+				case key[n] == idx[3]:
+					found = int(n)
+					fmt.Fprint(bw, "*")
+				case key[n] == 0:
+					empty++
+				}
+			}
+			fmt.Fprintln(bw)
+		}
+
+		switch {
+		case found >= 0:
+			fmt.Fprintf(bw, "found row %d slot %d\n", uint64(found)/width, found)
+		case empty > 0:
+			fmt.Fprintf(bw, "absent; %d empty candidate slots so the key was not inserted or was removed\n", empty)
+		default:
+			fmt.Fprintln(bw, "absent; the candidate rows are full so an insert requires a shuffle")
+		}
+	}
+
+	return bw.Flush()
+}
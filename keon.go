@@ -1,9 +1,10 @@
 package kvs
 
 import (
-	"bufio"
+	"context"
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"io"
 	"os"
 	"time"
@@ -38,6 +39,10 @@ import (
 // KEON is a set-only hash table structure
 type KEON struct {
 	path              string   // path to file
+	wal               *wal     // write-ahead log
+	name              string   // metrics table name
+	metrics           Metrics  // optional metrics hook
+	stats             stats    // insert shuffle statistics
 	count, max        uint64   // count of items, and max items
 	depth, width      uint64   // depth and width to establish hash bucket locations [ key|key|key ]
 	density, shuffler uint64   // options
@@ -78,18 +83,27 @@ func NewKEON(n uint64, opt *Option) *KEON {
 
 // LoadKEON a *KEON from disk and validate the checksum and signature.
 func LoadKEON(path string) (*KEON, bool) {
+	kn, err := LoadKEONContext(context.Background(), path, nil)
+	return kn, err == nil
+}
+
+// LoadKEONContext is LoadKEON that stops with the context error when the
+// context is done and reports the keys loaded to the optional progress;
+// the *KEON is returned with the error of a checksum or signature failure.
+// The keys are read and decoded in parallel chunks with ReadAt.
+func LoadKEONContext(ctx context.Context, path string, progress Progress) (*KEON, error) {
 
 	f, err := os.Open(path)
 	if err != nil {
-		return nil, false // bad file
+		return nil, err // bad file
 	}
 	defer f.Close()
 
-	var buf = bufio.NewReader(f)
-	var signature, checksum, index uint64
+	var signature, checksum uint64
 	var header [80]byte
-	var k [8]byte
-	io.ReadFull(buf, header[:])
+	if _, err = f.ReadAt(header[:], 0); err != nil && err != io.EOF {
+		return nil, err
+	}
 	signature = binary.BigEndian.Uint64(header[:8])
 	checksum = binary.BigEndian.Uint64(header[8:16])
 	// timestamp = binary.BigEndian.Uint64(header[16:24])
@@ -106,15 +120,37 @@ func LoadKEON(path string) (*KEON, bool) {
 	}
 	kn.sizer(false)
 
-	for {
-		_, err = io.ReadFull(buf, k[:])
-		if err != nil {
-			// io.EOF or io.UnexpectedEOF
-			return kn, signature == 0xff01 && checksum == kn.Checksum()
+	// a short file leaves the missing keys empty and fails the checksum
+	var n = uint64(len(kn.key))
+	var m = newMeter(ctx, n, progress)
+	var sums = make([]uint64, chunkCount(n))
+	if err = chunks(n, 8, func(i int, from, to uint64, buf []byte) error {
+		size, err := f.ReadAt(buf, 80+int64(from)*8)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		var sum uint64
+		for j := 0; j+8 <= size; j += 8 {
+			k := binary.BigEndian.Uint64(buf[j:])
+			kn.key[from+uint64(j/8)] = k
+			sum ^= k
 		}
-		kn.key[index] = binary.BigEndian.Uint64(k[:])
-		index++
+		sums[i] = sum
+		return m.add(to - from)
+	}); err != nil {
+		return nil, err
+	}
+	if err = m.done(n); err != nil {
+		return nil, err
+	}
+
+	for _, sum := range sums {
+		checksum ^= sum // zero when valid
+	}
+	if signature != 0xff01 || checksum != 0 {
+		return kn, errors.New("kvs: checksum failure " + path)
 	}
+	return kn, nil
 
 }
 
@@ -131,37 +167,71 @@ func (kn *KEON) Write(path string) error {
 	return kn.Save()
 }
 
+// WriteContext is Write with the context and progress of SaveContext.
+func (kn *KEON) WriteContext(ctx context.Context, path string, progress Progress) error {
+	kn.path = path
+	return kn.SaveContext(ctx, progress)
+}
+
 // Save *KEON to disk at prior Load/Write path
-func (kn *KEON) Save() error {
+func (kn *KEON) Save() error { return kn.SaveContext(context.Background(), nil) }
+
+// SaveContext is Save that stops with the context error when the context
+// is done, leaving the prior file in place, and reports the keys written
+// to the optional progress; the keys are encoded and written in parallel
+// chunks with WriteAt.
+func (kn *KEON) SaveContext(ctx context.Context, progress Progress) (err error) {
 
+	defer func(start time.Time) { saved(kn.metrics, kn.name, start, err) }(time.Now())
 	if len(kn.path) == 0 {
 		kn.path = "kvs.keon"
 	}
 
-	f, err := os.Create(kn.path)
+	// write to a temporary file that replaces the prior
+	// file only once it is complete and synced to disk
+	f, err := os.Create(kn.path + ".tmp")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(f.Name())
 	defer f.Close()
 
 	// 0xff01 is the keon header signature type
-	var buf = bufio.NewWriter(f)
-	var b [8]byte
-	for _, v := range []uint64{
+	var header [80]byte
+	for i, v := range []uint64{
 		0xff01, kn.Checksum(), uint64(time.Now().Unix()),
 		kn.count, kn.max, kn.depth, kn.width, kn.density, kn.shuffler, uint64(kn.tracker),
 	} {
-		binary.BigEndian.PutUint64(b[:], v)
-		buf.Write(b[:])
+		binary.BigEndian.PutUint64(header[i*8:], v)
+	}
+	if _, err = f.WriteAt(header[:], 0); err != nil {
+		return err
 	}
 
-	for i := uint64(0); i < uint64(len(kn.key)); i++ {
-		binary.BigEndian.PutUint64(b[:], kn.key[i])
-		buf.Write(b[:])
+	var n = uint64(len(kn.key))
+	var m = newMeter(ctx, n, progress)
+	if err = chunks(n, 8, func(_ int, from, to uint64, buf []byte) error {
+		for j, k := range kn.key[from:to] {
+			binary.BigEndian.PutUint64(buf[j*8:], k)
+		}
+		if _, err := f.WriteAt(buf, 80+int64(from)*8); err != nil {
+			return err
+		}
+		return m.add(to - from)
+	}); err != nil {
+		return err
+	}
+	if err = m.done(n); err != nil {
+		return err
 	}
 
-	buf.Flush()
-	return f.Sync()
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), kn.path)
 }
 
 // Export all bucket hash data excluding empty buckets
@@ -183,9 +253,27 @@ func (kn *KEON) Export() func(b *[8]byte) bool {
 	}
 }
 
+// ExportSlot is Export with the slot index of each bucket hash where
+// the row is slot / width; empty buckets are excluded
+func (kn *KEON) ExportSlot() func(b *[8]byte) (uint64, bool) {
+	var item int
+	return func(b *[8]byte) (uint64, bool) {
+		for item < len(kn.key) {
+			if kn.key[item] == 0 {
+				item++
+				continue
+			}
+			binary.BigEndian.PutUint64(b[:], kn.key[item])
+			item++
+			return uint64(item - 1), true
+		}
+		return 0, false
+	}
+}
+
 /*
 	KEON utility and information methods
-		sizer, Checksum
+		sizer, Checksum, calculate, find, option
 		Len, Cap, Ratio, Ident
 
 */
@@ -207,11 +295,13 @@ func (kn *KEON) sizer(calculate bool) *KEON {
 
 // Checksum generates an order independant numeric
 // using the KEON key; empty buckets have no impact
-func (kn *KEON) Checksum() (checksum uint64) {
-	for i := range kn.key {
-		checksum ^= kn.key[i] // XOR
-	}
-	return checksum
+func (kn *KEON) Checksum() uint64 { return checksum(kn.key) }
+
+// clone is a copy of the *KEON that shares only the write-ahead log
+func (kn *KEON) clone() *KEON {
+	var c = *kn
+	c.key = append([]uint64(nil), kn.key...)
+	return &c
 }
 
 // calculate target index locations using the current key hash via XOR with prime mixing
@@ -222,6 +312,31 @@ func (kn *KEON) calculate(idx *[4]uint64) {
 	idx[2] = kn.width * ((idx[kn.hloc] ^ 9650029242287828579) % kn.depth)  // prime4 9650029242287828579
 }
 
+// find the key hash using call local index locations so
+// that it is safe to use by concurrent readers
+func (kn *KEON) find(hash uint64) bool {
+	var idx [4]uint64
+	idx[kn.hloc] = hash
+	kn.calculate(&idx)
+	for i := uint64(0); i < kn.hloc; i++ {
+		for j := uint64(0); j < kn.width; j++ {
+			if kn.key[idx[i]+j] == hash {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// option reports the *KEON format as an *Option to create a like table
+func (kn *KEON) option() *Option {
+	var opt = &Option{Density: kn.density, Width: kn.width, Shuffler: kn.shuffler, Tracker: kn.tracker}
+	if opt.Density == 0 {
+		opt.Density = 1000 // no padding
+	}
+	return opt
+}
+
 // Len is number of current entries.
 func (kn *KEON) Len() uint64 { return kn.count }
 
@@ -243,14 +358,19 @@ func (kn *KEON) Ratio() uint64 {
 */
 
 // Lookup key in *KEON.
-func (kn *KEON) Lookup() func(key []byte) bool {
+func (kn *KEON) Lookup() func(key []byte) bool { return kn.lookup(xxhash.Sum) }
+func (kn *KEON) RawLookup() func(key []byte) bool {
+	return kn.lookup(func(raw []byte) uint64 { return binary.BigEndian.Uint64(raw) })
+}
+
+func (kn *KEON) lookup(encoder func([]byte) uint64) func(key []byte) bool {
 
 	var idx [4]uint64 // index locations
 	var n, i, j uint64
 
-	return func(key []byte) bool {
+	var lookup = func(key []byte) bool {
 
-		idx[kn.hloc] = xxhash.Sum(key)
+		idx[kn.hloc] = encoder(key) // eg. xxhash.Sum(key)
 		kn.calculate(&idx)
 
 		for i = 0; i < kn.hloc; i++ {
@@ -264,6 +384,15 @@ func (kn *KEON) Lookup() func(key []byte) bool {
 
 		return false
 	}
+
+	if m, name := kn.metrics, kn.name; m != nil {
+		return func(key []byte) bool {
+			found := lookup(key)
+			m.Lookup(name, found)
+			return found
+		}
+	}
+	return lookup
 }
 
 // Remove key from *KEON.
@@ -300,6 +429,9 @@ func (kn *KEON) remove(encoder func([]byte) uint64) func(key []byte) struct{ Ok,
 
 					kn.count--
 					item.Exist = true
+					if kn.wal != nil {
+						kn.wal.append(walRemove, idx[kn.hloc], 0)
+					}
 					return
 				}
 			}
@@ -329,18 +461,23 @@ func (kn *KEON) insert(update bool, encoder func([]byte) uint64) func([]byte) st
 	var n, i, j uint64 // counters
 	var ix, jx uint64  // counters
 	var empty bool     // flags
+	var hash uint64    // inserted key
+	var swaps uint64   // shuffle swaps of the insert
 
 	var node [2]uint64
 	var cyclic map[[2]uint64]uint8
 
-	return func(key []byte) (item struct{ Ok, Exist, NoSpace bool }) {
+	var insert = func(key []byte) (item struct{ Ok, Exist, NoSpace bool }) {
 
+		swaps = 0
 		if kn.count == kn.max {
+			kn.stats.Full++
 			item.NoSpace = true
 			return
 		}
 
 		idx[kn.hloc] = encoder(key) // xxhash.Sum(key)
+		hash = idx[kn.hloc]
 		kn.calculate(&idx)
 		empty = false
 
@@ -368,7 +505,11 @@ func (kn *KEON) insert(update bool, encoder func([]byte) uint64) func([]byte) st
 		if empty {
 			kn.key[idx[ix]+jx] = idx[kn.hloc]
 			kn.count++
+			kn.stats.Direct++
 			item.Ok = true
+			if kn.wal != nil {
+				kn.wal.append(walInsert, hash, 0)
+			}
 			return
 		}
 
@@ -386,6 +527,7 @@ func (kn *KEON) insert(update bool, encoder func([]byte) uint64) func([]byte) st
 				node = [2]uint64{ix, idx[kn.hloc]}                    // cyclic node generation; index and key
 				cyclic[node]++                                        // cyclic recurrent node movement tracking
 				if cyclic[node] > uint8(kn.width) || len(cyclic) == kn.tracker {
+					kn.stats.Aborts++
 					break // reset cyclic path tracker and jump tracks by picking a new random index
 					// and key to displace as this gives us about ~2x faster performance boost by
 					// locating an open slot faster for some reason
@@ -393,6 +535,7 @@ func (kn *KEON) insert(update bool, encoder func([]byte) uint64) func([]byte) st
 
 				kn.key[n], idx[kn.hloc] = idx[kn.hloc], kn.key[n] // swap keys to displace the key
 				kn.calculate(&idx)                                // generate index set for displaced key
+				swaps++
 
 				for i = 0; i < kn.hloc; i++ { // attempt to insert displaced key in alternate location
 					if idx[i] != ix { // avoid the common index between key and displaced key
@@ -400,8 +543,13 @@ func (kn *KEON) insert(update bool, encoder func([]byte) uint64) func([]byte) st
 							n = idx[i] + j
 							if kn.key[n] == 0 { // a new location for displaced key
 								kn.key[n] = idx[kn.hloc]
+								onset(&kn.stats, kn.count, kn.max)
 								kn.count++
+								displaced(&kn.stats, swaps)
 								item.Ok = true
+								if kn.wal != nil {
+									kn.wal.append(walInsert, hash, 0)
+								}
 								return
 							}
 						}
@@ -412,7 +560,17 @@ func (kn *KEON) insert(update bool, encoder func([]byte) uint64) func([]byte) st
 		}
 
 		// ran out of key shuffle options
+		kn.stats.Exhausted++
 		item.NoSpace = true
 		return
 	}
+
+	if m, name := kn.metrics, kn.name; m != nil {
+		return func(key []byte) (item struct{ Ok, Exist, NoSpace bool }) {
+			item = insert(key)
+			m.Insert(name, item, swaps)
+			return
+		}
+	}
+	return insert
 }
@@ -1,9 +1,10 @@
 package kvs
 
 import (
-	"bufio"
+	"context"
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"io"
 	"os"
 	"time"
@@ -39,6 +40,10 @@ import (
 // KEVA is a set-only hash table structure
 type KEVA struct {
 	path              string   // path to file
+	wal               *wal     // write-ahead log
+	name              string   // metrics table name
+	metrics           Metrics  // optional metrics hook
+	stats             stats    // insert shuffle statistics
 	count, max        uint64   // count of items, and max items
 	depth, width      uint64   // depth and width to establish hash bucket locations [ key|key|key ]
 	density, shuffler uint64   // options
@@ -84,18 +89,27 @@ func NewKEVA(n uint64, opt *Option) *KEVA {
 
 // Load a *KEVA from disk and validate the checksum and signature.
 func LoadKEVA(path string) (*KEVA, bool) {
+	kn, err := LoadKEVAContext(context.Background(), path, nil)
+	return kn, err == nil
+}
+
+// LoadKEVAContext is LoadKEVA that stops with the context error when the
+// context is done and reports the keys loaded to the optional progress;
+// the *KEVA is returned with the error of a checksum or signature failure.
+// The slots are read and decoded in parallel chunks with ReadAt.
+func LoadKEVAContext(ctx context.Context, path string, progress Progress) (*KEVA, error) {
 
 	f, err := os.Open(path)
 	if err != nil {
-		return nil, false // bad file
+		return nil, err // bad file
 	}
 	defer f.Close()
 
-	var signature, checksum, index uint64
+	var signature, checksum uint64
 	var header [80]byte
-	var buf = bufio.NewReader(f)
-	var kv [16]byte // uint64x2 k:8 v:8
-	io.ReadFull(buf, header[:])
+	if _, err = f.ReadAt(header[:], 0); err != nil && err != io.EOF {
+		return nil, err
+	}
 	signature = binary.BigEndian.Uint64(header[:8])
 	checksum = binary.BigEndian.Uint64(header[8:16])
 	// timestamp = binary.BigEndian.Uint64(header[16:24])
@@ -111,17 +125,40 @@ func LoadKEVA(path string) (*KEVA, bool) {
 		tracker:  int(binary.BigEndian.Uint64(header[72:])),
 	}
 	kn.sizer(false)
-	for {
-		_, err = io.ReadFull(buf, kv[:])
-		if err != nil {
-			// io.EOF or io.UnexpectedEOF
-			return kn, checksum == kn.Checksum() && signature == 0xff02
+	// a short file leaves the missing slots empty and fails the checksum;
+	// each slot is uint64x2 k:8 v:8
+	var n = uint64(len(kn.key))
+	var m = newMeter(ctx, n, progress)
+	var sums = make([]uint64, chunkCount(n))
+	if err = chunks(n, 16, func(i int, from, to uint64, buf []byte) error {
+		size, err := f.ReadAt(buf, 80+int64(from)*16)
+		if err != nil && err != io.EOF {
+			return err
 		}
-		kn.key[index] = binary.BigEndian.Uint64(kv[:8])
-		kn.value[index] = binary.BigEndian.Uint64(kv[8:])
-		index++
+		var sum uint64
+		for j := 0; j+16 <= size; j += 16 {
+			k := binary.BigEndian.Uint64(buf[j:])
+			kn.key[from+uint64(j/16)] = k
+			kn.value[from+uint64(j/16)] = binary.BigEndian.Uint64(buf[j+8:])
+			sum ^= k
+		}
+		sums[i] = sum
+		return m.add(to - from)
+	}); err != nil {
+		return nil, err
+	}
+	if err = m.done(n); err != nil {
+		return nil, err
 	}
 
+	for _, sum := range sums {
+		checksum ^= sum // zero when valid
+	}
+	if checksum != 0 || signature != 0xff02 {
+		return kn, errors.New("kvs: checksum failure " + path)
+	}
+	return kn, nil
+
 }
 
 /*
@@ -137,39 +174,72 @@ func (kn *KEVA) Write(path string) error {
 	return kn.Save()
 }
 
+// WriteContext is Write with the context and progress of SaveContext.
+func (kn *KEVA) WriteContext(ctx context.Context, path string, progress Progress) error {
+	kn.path = path
+	return kn.SaveContext(ctx, progress)
+}
+
 // Save *KEVA to disk at prior Load/Write path
-func (kn *KEVA) Save() error {
+func (kn *KEVA) Save() error { return kn.SaveContext(context.Background(), nil) }
 
+// SaveContext is Save that stops with the context error when the context
+// is done, leaving the prior file in place, and reports the keys written
+// to the optional progress; the slots are encoded and written in parallel
+// chunks with WriteAt.
+func (kn *KEVA) SaveContext(ctx context.Context, progress Progress) (err error) {
+
+	defer func(start time.Time) { saved(kn.metrics, kn.name, start, err) }(time.Now())
 	if len(kn.path) == 0 {
 		kn.path = "kvs.keva"
 	}
 
-	f, err := os.Create(kn.path)
+	// write to a temporary file that replaces the prior
+	// file only once it is complete and synced to disk
+	f, err := os.Create(kn.path + ".tmp")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(f.Name())
 	defer f.Close()
 
 	// 0xff02 is the keva header signature type
-	var buf = bufio.NewWriter(f)
-	var b [8]byte
-	for _, v := range []uint64{
+	var header [80]byte
+	for i, v := range []uint64{
 		0xff02, kn.Checksum(), uint64(time.Now().Unix()),
 		kn.count, kn.max, kn.depth, kn.width, kn.density, kn.shuffler, uint64(kn.tracker),
 	} {
-		binary.BigEndian.PutUint64(b[:], v)
-		buf.Write(b[:])
+		binary.BigEndian.PutUint64(header[i*8:], v)
+	}
+	if _, err = f.WriteAt(header[:], 0); err != nil {
+		return err
 	}
 
-	for i := uint64(0); i < uint64(len(kn.key)); i++ {
-		binary.BigEndian.PutUint64(b[:], kn.key[i])
-		buf.Write(b[:])
-		binary.BigEndian.PutUint64(b[:], kn.value[i])
-		buf.Write(b[:])
+	var n = uint64(len(kn.key))
+	var m = newMeter(ctx, n, progress)
+	if err = chunks(n, 16, func(_ int, from, to uint64, buf []byte) error {
+		for j := uint64(0); j < to-from; j++ {
+			binary.BigEndian.PutUint64(buf[j*16:], kn.key[from+j])
+			binary.BigEndian.PutUint64(buf[j*16+8:], kn.value[from+j])
+		}
+		if _, err := f.WriteAt(buf, 80+int64(from)*16); err != nil {
+			return err
+		}
+		return m.add(to - from)
+	}); err != nil {
+		return err
+	}
+	if err = m.done(n); err != nil {
+		return err
 	}
 
-	buf.Flush()
-	return f.Sync()
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), kn.path)
 }
 
 // Export all bucket hash data excluding empty buckets
@@ -190,9 +260,28 @@ func (kn *KEVA) Export() func(*[8]byte, *[8]byte) bool {
 	}
 }
 
+// ExportSlot is Export with the slot index of each bucket hash and value
+// where the row is slot / width; empty buckets are excluded
+func (kn *KEVA) ExportSlot() func(*[8]byte, *[8]byte) (uint64, bool) {
+	var item int
+	return func(k, v *[8]byte) (uint64, bool) {
+		for item < len(kn.key) {
+			if kn.key[item] == 0 {
+				item++
+				continue
+			}
+			binary.BigEndian.PutUint64(k[:], kn.key[item])
+			binary.BigEndian.PutUint64(v[:], kn.value[item])
+			item++
+			return uint64(item - 1), true
+		}
+		return 0, false
+	}
+}
+
 /*
 	KEVA utility and information methods
-		sizer
+		sizer, Checksum, calculate, find, option
 		Len, Cap, Ratio, Ident
 
 */
@@ -215,11 +304,14 @@ func (kn *KEVA) sizer(calculate bool) *KEVA {
 
 // Checksum generates an order independant numeric
 // using the KEVA key; empty buckets have no impact
-func (kn *KEVA) Checksum() (checksum uint64) {
-	for i := range kn.key {
-		checksum ^= kn.key[i] // XOR
-	}
-	return checksum
+func (kn *KEVA) Checksum() uint64 { return checksum(kn.key) }
+
+// clone is a copy of the *KEVA that shares only the write-ahead log
+func (kn *KEVA) clone() *KEVA {
+	var c = *kn
+	c.key = append([]uint64(nil), kn.key...)
+	c.value = append([]uint64(nil), kn.value...)
+	return &c
 }
 
 // calculate target index locations using the current key hash via XOR with prime mixing
@@ -230,6 +322,31 @@ func (kn *KEVA) calculate(idx *[4]uint64) {
 	idx[2] = kn.width * ((idx[kn.hloc] ^ 9650029242287828579) % kn.depth)  // prime4 9650029242287828579
 }
 
+// find the key hash and value using call local index locations
+// so that it is safe to use by concurrent readers
+func (kn *KEVA) find(hash uint64) (uint64, bool) {
+	var idx [4]uint64
+	idx[kn.hloc] = hash
+	kn.calculate(&idx)
+	for i := uint64(0); i < kn.hloc; i++ {
+		for j := uint64(0); j < kn.width; j++ {
+			if kn.key[idx[i]+j] == hash {
+				return kn.value[idx[i]+j], true
+			}
+		}
+	}
+	return 0, false
+}
+
+// option reports the *KEVA format as an *Option to create a like table
+func (kn *KEVA) option() *Option {
+	var opt = &Option{Density: kn.density, Width: kn.width, Shuffler: kn.shuffler, Tracker: kn.tracker}
+	if opt.Density == 0 {
+		opt.Density = 1000 // no padding
+	}
+	return opt
+}
+
 // Len is number of current entries.
 func (kn *KEVA) Len() uint64 { return kn.count }
 
@@ -255,16 +372,29 @@ func (kn *KEVA) Lookup() func(key []byte) (item struct {
 	Value uint64
 	Ok    bool
 }) {
+	return kn.lookup(xxhash.Sum)
+}
+func (kn *KEVA) RawLookup() func(key []byte) (item struct {
+	Value uint64
+	Ok    bool
+}) {
+	return kn.lookup(func(raw []byte) uint64 { return binary.BigEndian.Uint64(raw) })
+}
+
+func (kn *KEVA) lookup(encoder func([]byte) uint64) func(key []byte) (item struct {
+	Value uint64
+	Ok    bool
+}) {
 
 	var idx [4]uint64
 	var n, i, j uint64
 
-	return func(key []byte) (item struct {
+	var lookup = func(key []byte) (item struct {
 		Value uint64
 		Ok    bool
 	}) {
 
-		idx[kn.hloc] = xxhash.Sum(key)
+		idx[kn.hloc] = encoder(key) // eg. xxhash.Sum(key)
 		kn.calculate(&idx)
 
 		for i = 0; i < kn.hloc; i++ {
@@ -279,6 +409,18 @@ func (kn *KEVA) Lookup() func(key []byte) (item struct {
 		}
 		return
 	}
+
+	if m, name := kn.metrics, kn.name; m != nil {
+		return func(key []byte) (item struct {
+			Value uint64
+			Ok    bool
+		}) {
+			item = lookup(key)
+			m.Lookup(name, item.Ok)
+			return
+		}
+	}
+	return lookup
 }
 
 // Remove key from *KEVA.
@@ -317,6 +459,9 @@ func (kn *KEVA) remove(encoder func([]byte) uint64) func(key []byte) struct{ Ok,
 
 					kn.count--
 					item.Exist = true
+					if kn.wal != nil {
+						kn.wal.append(walRemove, idx[kn.hloc], 0)
+					}
 					return
 				}
 			}
@@ -345,18 +490,23 @@ func (kn *KEVA) insert(update bool, encoder func([]byte) uint64) func([]byte, ui
 	var n, i, j uint64
 	var ix, jx uint64
 	var empty bool
+	var hash uint64
+	var swaps uint64
 
 	var node [2]uint64
 	var cyclic map[[2]uint64]uint8
 
-	return func(key []byte, value uint64) (item struct{ Ok, Exist, NoSpace bool }) {
+	var insert = func(key []byte, value uint64) (item struct{ Ok, Exist, NoSpace bool }) {
 
+		swaps = 0
 		item.NoSpace = kn.count == kn.max
 		if item.NoSpace {
+			kn.stats.Full++
 			return
 		}
 
 		idx[kn.hloc] = encoder(key)
+		hash = idx[kn.hloc]
 		kn.calculate(&idx)
 		empty = false
 
@@ -385,7 +535,11 @@ func (kn *KEVA) insert(update bool, encoder func([]byte) uint64) func([]byte, ui
 			kn.key[idx[ix]+jx] = idx[kn.hloc]
 			kn.value[idx[ix]+jx] = value
 			kn.count++
+			kn.stats.Direct++
 			item.Ok = true
+			if kn.wal != nil {
+				kn.wal.append(walInsert, hash, value)
+			}
 			return
 		}
 
@@ -404,6 +558,7 @@ func (kn *KEVA) insert(update bool, encoder func([]byte) uint64) func([]byte, ui
 				node = [2]uint64{ix, idx[kn.hloc]}                    // cyclic node generation; index and key
 				cyclic[node]++                                        // cyclic recurrent node movement tracking
 				if cyclic[node] > uint8(kn.width) || len(cyclic) == kn.tracker {
+					kn.stats.Aborts++
 					break // reset cyclic path tracker and jump shuffle by picking a new random index
 					// and key to displace, as this gives us about ~2x faster performance boost by
 					// locating an open slot faster rather than cycling back over prior shifts
@@ -412,16 +567,22 @@ func (kn *KEVA) insert(update bool, encoder func([]byte) uint64) func([]byte, ui
 				kn.key[n], idx[kn.hloc] = idx[kn.hloc], kn.key[n] // swap keys to displace the key
 				kn.value[n], displace = displace, kn.value[n]     // swap values to displace the value
 				kn.calculate(&idx)                                // generate index set for displaced key
+				swaps++
 
-				for i = 0; i < kn.hloc-1; i++ { // attempt to insert displaced key in alternate location
+				for i = 0; i < kn.hloc; i++ { // attempt to insert displaced key in alternate location
 					if idx[i] != ix { // avoid the common index between key and displaced key
 						for j = 0; j < kn.width; j++ {
 							n = idx[i] + j
 							if kn.key[n] == 0 { // a new location for displaced key and value
 								kn.key[n] = idx[kn.hloc]
 								kn.value[n] = displace
+								onset(&kn.stats, kn.count, kn.max)
 								kn.count++
+								displaced(&kn.stats, swaps)
 								item.Ok = true
+								if kn.wal != nil {
+									kn.wal.append(walInsert, hash, value)
+								}
 								return
 							}
 						}
@@ -432,7 +593,17 @@ func (kn *KEVA) insert(update bool, encoder func([]byte) uint64) func([]byte, ui
 		}
 
 		// ran out of key shuffle options
+		kn.stats.Exhausted++
 		item.NoSpace = true
 		return
 	}
+
+	if m, name := kn.metrics, kn.name; m != nil {
+		return func(key []byte, value uint64) (item struct{ Ok, Exist, NoSpace bool }) {
+			item = insert(key, value)
+			m.Insert(name, item, swaps)
+			return
+		}
+	}
+	return insert
 }
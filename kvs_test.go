@@ -2,12 +2,21 @@ package kvs_test
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"net"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/zxdev/kvs"
+	"github.com/zxdev/kvs/client"
+	"github.com/zxdev/kvs/metrics"
 	"github.com/zxdev/xxhash"
 )
 
@@ -322,6 +331,39 @@ func TestExport(t *testing.T) {
 
 }
 
+// go test -v -run ExportSlot
+func TestExportSlot(t *testing.T) {
+
+	size := uint64(50)
+	kv := kvs.NewKEVA(size, nil)
+	insert := kv.Insert(false)
+	for i := uint64(0); i < size; i++ {
+		insert([]byte{byte(i + 1), 4, 0, 0, 0, 0, 0, 0}, i+1)
+	}
+
+	var count uint64
+	var last = -1
+	var k, v, ek, ev [8]byte
+	export := kv.Export()
+	next := kv.ExportSlot()
+	lookup := kv.RawLookup()
+	for slot, ok := next(&k, &v); ok; slot, ok = next(&k, &v) {
+		export(&ek, &ev)
+		item := lookup(k[:])
+		if int(slot) <= last || k != ek || v != ev || !item.Ok || item.Value != binary.BigEndian.Uint64(v[:]) {
+			t.Log("export slot failure", slot, k, v)
+			t.FailNow()
+		}
+		last = int(slot)
+		count++
+	}
+	if count != size {
+		t.Log("export slot count failure", count)
+		t.FailNow()
+	}
+
+}
+
 // go test -v -run Alexa
 func TestAlexa(t *testing.T) {
 
@@ -645,3 +687,1249 @@ func TestMerge(t *testing.T) {
 	t.Log("stats", kn1.Len(), kn1.Cap(), kn1.Checksum())
 	kn1.Write(f3)
 }
+
+// go test -v -run MergeFrom
+func TestMergeFrom(t *testing.T) {
+
+	// 	=== RUN   TestMergeFrom
+	//     kvs_test.go:677: == MERGE FROM ==
+	//     kvs_test.go:684: stats 75 100 179520822399970802
+	//     kvs_test.go:686: == MERGE FROM PARALLEL ==
+	//     kvs_test.go:693: stats 75 100 179520822399970802
+	// --- PASS: TestMergeFrom (0.00s)
+
+	size := uint64(100)
+
+	// kn1 holds the first half and kn2 the middle half so
+	// that half of kn2 already exists in kn1 and half is new
+	build := func() (*kvs.KEON, *kvs.KEON) {
+		kn1 := kvs.NewKEON(size, nil)
+		insert1 := kn1.Insert(false)
+		for i := uint64(0); i < size/2; i++ {
+			insert1([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0})
+		}
+		kn2 := kvs.NewKEON(size, nil)
+		insert2 := kn2.Insert(false)
+		for i := uint64(25); i < size-25; i++ {
+			insert2([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0})
+		}
+		return kn1, kn2
+	}
+
+	t.Log("== MERGE FROM ==")
+	kn1, kn2 := build()
+	r := kn1.MergeFrom(kn2, nil)
+	if !r.Ok || r.Items != 25 || kn1.Len() != 75 || kn1.Checksum() != 179520822399970802 {
+		t.Log("merge failure", r)
+		t.FailNow()
+	}
+	t.Log("stats", kn1.Len(), kn1.Cap(), kn1.Checksum())
+
+	t.Log("== MERGE FROM PARALLEL ==")
+	kn1, kn2 = build()
+	r = kn1.MergeFromParallel(kn2, nil, 4)
+	if !r.Ok || r.Items != 25 || kn1.Len() != 75 || kn1.Checksum() != 179520822399970802 {
+		t.Log("merge failure", r)
+		t.FailNow()
+	}
+	t.Log("stats", kn1.Len(), kn1.Cap(), kn1.Checksum())
+
+	// removing kn2 leaves only the first quarter
+	r = kn1.MergeFromParallel(kn2, false, 4)
+	if !r.Ok || r.Items != 50 || kn1.Len() != 25 {
+		t.Log("remove failure", r)
+		t.FailNow()
+	}
+
+	kv1, kv2 := kvs.NewKEVA(size, nil), kvs.NewKEVA(size, nil)
+	insert1, insert2 := kv1.Insert(false), kv2.Insert(false)
+	for i := uint64(0); i < size/2; i++ {
+		insert1([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0}, i)
+		insert2([]byte{byte(i + 51), 0, 0, 0, 0, 0, 0, 0}, i+50)
+	}
+	r = kv1.MergeFrom(kv2, nil)
+	if !r.Ok || r.Items != 50 || kv1.Len() != 100 {
+		t.Log("keva merge failure", r)
+		t.FailNow()
+	}
+	lookup := kv1.Lookup()
+	for i := uint64(0); i < size; i++ {
+		if item := lookup([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0}); !item.Ok || item.Value != i {
+			t.Log("keva lookup failure", i, item)
+			t.FailNow()
+		}
+	}
+
+}
+
+// go test -v -run MergeFiles
+func TestMergeFiles(t *testing.T) {
+
+	// 	=== RUN   TestMergeFiles
+	//     kvs_test.go:761: sandbox/shard0.keon true 40 40
+	//     kvs_test.go:761: sandbox/shard1.keon true 40 20
+	//     kvs_test.go:761: sandbox/shard2.keon true 40 20
+	//     kvs_test.go:763: stats 80 80 80
+	// --- PASS: TestMergeFiles (0.00s)
+
+	os.Mkdir("sandbox", 0755)
+
+	// each shard overlaps the prior shard by half
+	var paths []string
+	for s := 0; s < 3; s++ {
+		kn := kvs.NewKEON(40, nil)
+		insert := kn.Insert(false)
+		for i := 0; i < 40; i++ {
+			insert([]byte{byte(s*20 + i + 1), 0, 0, 0, 0, 0, 0, 0})
+		}
+		paths = append(paths, "sandbox/shard"+string(rune('0'+s))+".keon")
+		kn.Write(paths[s])
+		defer os.Remove(paths[s])
+	}
+
+	// the composite checksum must match a table built directly
+	kn0 := kvs.NewKEON(80, nil)
+	insert := kn0.Insert(false)
+	for i := 0; i < 80; i++ {
+		insert([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0})
+	}
+
+	kn, r := kvs.MergeFiles(paths, nil)
+	if !r.Ok || kn.Len() != 80 || kn.Checksum() != kn0.Checksum() {
+		t.Log("merge failure", r)
+		t.FailNow()
+	}
+	for _, src := range r.Sources {
+		t.Log(src.Path, src.Ok, src.Count, src.Items)
+	}
+	t.Log("stats", kn.Len(), kn.Cap(), r.Items)
+
+	// a perfect hash can not hold the composite; the partial table is returned
+	if kn, r = kvs.MergeFiles(paths, &kvs.Option{Density: 1000, Width: 1, Shuffler: 1}); !r.NoSpace || kn == nil || kn.Cap() < 80 {
+		t.Log("merge nospace failure", r)
+		t.FailNow()
+	}
+
+	// a missing source invalidates the merge
+	if _, r = kvs.MergeFiles(append(paths, "sandbox/missing.keon"), nil); r.Ok || !r.Invalid {
+		t.Log("invalid source accepted", r)
+		t.FailNow()
+	}
+
+}
+
+// go test -v -run Overlay
+func TestOverlay(t *testing.T) {
+
+	// 	=== RUN   TestOverlay
+	//     kvs_test.go:825: stats 50 5373087661334553314
+	//     kvs_test.go:852: compact 50 5373087661334553314
+	// --- PASS: TestOverlay (0.01s)
+
+	os.Mkdir("sandbox", 0755)
+	path := "sandbox/overlay.keon"
+	defer os.Remove(path)
+	defer os.Remove(path + ".delta")
+	defer os.Remove(path + ".tomb")
+
+	size := uint64(100)
+	base := kvs.NewKEON(size, nil)
+	insert := base.Insert(false)
+	for i := uint64(0); i < size/2; i++ {
+		insert([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0})
+	}
+
+	// the expected composite is the middle half after removing the
+	// first quarter from the base and adding the third quarter
+	expect := kvs.NewKEON(size, nil)
+	insert = expect.Insert(false)
+	for i := uint64(25); i < size-25; i++ {
+		insert([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0})
+	}
+
+	ov := kvs.NewOverlayKEON(base, size, nil)
+	ovInsert := ov.Insert(false)
+	ovRemove := ov.Remove()
+	ovLookup := ov.Lookup()
+	for i := uint64(25); i < size-25; i++ {
+		ovInsert([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0})
+	}
+	for i := uint64(0); i < 25; i++ {
+		if !ovRemove([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0}).Exist {
+			t.Log("remove failure", i)
+			t.FailNow()
+		}
+	}
+	for i := uint64(0); i < size; i++ {
+		if ovLookup([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0}) != (i >= 25 && i < size-25) {
+			t.Log("lookup failure", i)
+			t.FailNow()
+		}
+	}
+	if ov.Len() != 50 || ov.Checksum() != expect.Checksum() {
+		t.Log("checksum failure", ov.Len(), ov.Checksum(), expect.Checksum())
+		t.FailNow()
+	}
+	t.Log("stats", ov.Len(), ov.Checksum())
+
+	// restore a removed base key
+	if r := ovInsert([]byte{1, 0, 0, 0, 0, 0, 0, 0}); !r.Ok || r.Exist || !ovLookup([]byte{1, 0, 0, 0, 0, 0, 0, 0}) {
+		t.Log("restore failure", r)
+		t.FailNow()
+	}
+	ovRemove([]byte{1, 0, 0, 0, 0, 0, 0, 0})
+
+	if err := ov.Write(path); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if info := kvs.Info(path + ".tomb"); !info.Ok || info.Count != 25 {
+		t.Log("tomb info failure", info)
+		t.FailNow()
+	}
+
+	ov, ok := kvs.LoadOverlayKEON(path, size, nil)
+	if !ok || ov.Checksum() != expect.Checksum() {
+		t.Log("load failure")
+		t.FailNow()
+	}
+	if !ov.Compact() || ov.Len() != 50 || ov.Checksum() != expect.Checksum() {
+		t.Log("compact failure", ov.Len())
+		t.FailNow()
+	}
+	t.Log("compact", ov.Len(), ov.Checksum())
+
+	// keva overlay updates a base value through the delta
+	kv := kvs.NewKEVA(size, nil)
+	kvInsert := kv.Insert(false)
+	for i := uint64(0); i < size/2; i++ {
+		kvInsert([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0}, i)
+	}
+	kvOverlay := kvs.NewOverlayKEVA(kv, size, nil)
+	kvOverlayInsert := kvOverlay.Insert(true)
+	kvOverlayLookup := kvOverlay.Lookup()
+	if r := kvOverlayInsert([]byte{1, 0, 0, 0, 0, 0, 0, 0}, 1000); !r.Ok || !r.Exist {
+		t.Log("keva update failure", r)
+		t.FailNow()
+	}
+	if item := kvOverlayLookup([]byte{1, 0, 0, 0, 0, 0, 0, 0}); !item.Ok || item.Value != 1000 || kvOverlay.Len() != 50 {
+		t.Log("keva lookup failure", item, kvOverlay.Len())
+		t.FailNow()
+	}
+
+	// an update of a delta key reports that the key exists
+	kvOverlayInsert([]byte{byte(size), 0, 0, 0, 0, 0, 0, 0}, 1)
+	if r := kvOverlayInsert([]byte{byte(size), 0, 0, 0, 0, 0, 0, 0}, 2); !r.Ok || !r.Exist ||
+		kvOverlayLookup([]byte{byte(size), 0, 0, 0, 0, 0, 0, 0}).Value != 2 {
+		t.Log("keva delta update failure", r)
+		t.FailNow()
+	}
+
+	// the compacted base keeps its write-ahead log
+	kvPath := "sandbox/overlay.keva"
+	defer os.Remove(kvPath)
+	defer os.Remove(kvPath + ".wal")
+	kv.Write(kvPath)
+	if err := kv.AttachWAL(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer kv.CloseWAL()
+	if !kvOverlay.Compact() || kvOverlay.Len() != 51 || kvOverlayLookup([]byte{1, 0, 0, 0, 0, 0, 0, 0}).Value != 1000 {
+		t.Log("keva compact failure")
+		t.FailNow()
+	}
+	kv.Insert(false)([]byte{byte(size + 1), 0, 0, 0, 0, 0, 0, 0}, 1)
+	kv.SyncWAL()
+	if fi, err := os.Stat(kvPath + ".wal"); err != nil || fi.Size() == 0 {
+		t.Log("compact wal failure", err)
+		t.FailNow()
+	}
+
+	// a remove with the tombstones at capacity fails and the key stays live
+	full := kvs.NewKEON(size, nil)
+	insert = full.Insert(false)
+	for i := uint64(0); i < size/2; i++ {
+		insert([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0})
+	}
+	ov = kvs.NewOverlayKEON(full, 1, nil)
+	ovRemove, ovLookup = ov.Remove(), ov.Lookup()
+	var removed uint64
+	for ; removed < size/2; removed++ {
+		key := []byte{byte(removed + 1), 0, 0, 0, 0, 0, 0, 0}
+		if r := ovRemove(key); !r.Ok {
+			if r.Exist || !ovLookup(key) || ov.Len() != size/2-removed {
+				t.Log("tomb full failure", r, ov.Len())
+				t.FailNow()
+			}
+			break
+		}
+	}
+	if removed == size/2 {
+		t.Log("tomb capacity failure")
+		t.FailNow()
+	}
+
+}
+
+// go test -v -run WAL
+func TestWAL(t *testing.T) {
+
+	// 	=== RUN   TestWAL
+	//     kvs_test.go:931: replay 75 75
+	//     kvs_test.go:943: checkpoint 75 0
+	// --- PASS: TestWAL (0.00s)
+
+	os.Mkdir("sandbox", 0755)
+	path := "sandbox/wal.keva"
+	defer os.Remove(path)
+	defer os.Remove(path + ".wal")
+
+	size := uint64(100)
+	kn := kvs.NewKEVA(size, nil)
+	insert := kn.Insert(false)
+	for i := uint64(0); i < size/2; i++ {
+		insert([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0}, i)
+	}
+	kn.Write(path)
+
+	// mutations after the snapshot only live in the log
+	if err := kn.AttachWAL(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	remove := kn.Remove()
+	for i := uint64(50); i < size; i++ {
+		insert([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0}, i)
+	}
+	for i := uint64(0); i < 25; i++ {
+		remove([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0})
+	}
+	checksum := kn.Checksum()
+	kn.CloseWAL()
+
+	// simulate a torn append at the tail of the log
+	f, _ := os.OpenFile(path+".wal", os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{1, 2, 3})
+	f.Close()
+
+	kn, err := kvs.OpenKEVAWithWAL(path)
+	if err != nil || kn.Checksum() != checksum || kn.Len() != 75 {
+		t.Log("replay failure", err)
+		t.FailNow()
+	}
+	lookup := kn.Lookup()
+	for i := uint64(0); i < size; i++ {
+		if item := lookup([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0}); item.Ok != (i >= 25) || item.Ok && item.Value != i {
+			t.Log("lookup failure", i, item)
+			t.FailNow()
+		}
+	}
+	t.Log("replay", kn.Len(), kvs.Info(path).Count+uint64(25))
+
+	if err = kn.Checkpoint(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	kn.CloseWAL()
+	stat, _ := os.Stat(path + ".wal")
+	if info := kvs.Info(path); info.Count != 75 || stat.Size() != 0 {
+		t.Log("checkpoint failure", info.Count, stat.Size())
+		t.FailNow()
+	}
+	t.Log("checkpoint", kvs.Info(path).Count, stat.Size())
+
+}
+
+// go test -v -run Handle
+func TestHandle(t *testing.T) {
+
+	// 	=== RUN   TestHandle
+	//     kvs_test.go:1006: reload 51
+	//     kvs_test.go:1018: reject kvs: checksum failure sandbox/handle.keon
+	// --- PASS: TestHandle (0.03s)
+
+	os.Mkdir("sandbox", 0755)
+	path := "sandbox/handle.keon"
+	defer os.Remove(path)
+
+	size := uint64(100)
+	kn := kvs.NewKEON(size, nil)
+	insert := kn.Insert(false)
+	for i := uint64(0); i < size/2; i++ {
+		insert([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0})
+	}
+	kn.Write(path)
+
+	h, err := kvs.NewHandle(path)
+	if err != nil || !h.Lookup([]byte{1, 0, 0, 0, 0, 0, 0, 0}).Ok {
+		t.Log("handle failure", err)
+		t.FailNow()
+	}
+
+	var reload = make(chan uint64, 1)
+	var reject = make(chan error, 1)
+	h.OnReload = func(info struct {
+		Signature, Checksum, Timestamp, Count, Max uint64
+		Depth, Width, Density, Shuffler, Tracker   uint64
+		Ok                                         bool
+	}) {
+		reload <- info.Count
+	}
+	h.OnReject = func(info struct {
+		Signature, Checksum, Timestamp, Count, Max uint64
+		Depth, Width, Density, Shuffler, Tracker   uint64
+		Ok                                         bool
+	}, err error) {
+		reject <- err
+	}
+	h.Watch(time.Millisecond)
+	defer h.Close()
+
+	// publish a new version while readers are active
+	var done = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				h.Lookup([]byte{1, 0, 0, 0, 0, 0, 0, 0})
+			}
+		}
+	}()
+	insert([]byte{byte(size), 0, 0, 0, 0, 0, 0, 0})
+	kn.Write(path)
+	t.Log("reload", <-reload)
+	close(done)
+	if !h.Lookup([]byte{byte(size), 0, 0, 0, 0, 0, 0, 0}).Ok || h.Info().Count != 51 {
+		t.Log("reload lookup failure")
+		t.FailNow()
+	}
+
+	// publish a new version with a corrupt body
+	b, _ := os.ReadFile(path)
+	b[23]++ // timestamp
+	b[len(b)-1] ^= 0xff
+	os.WriteFile(path, b, 0644)
+	t.Log("reject", <-reject)
+	if h.Info().Count != 51 || !h.Lookup([]byte{byte(size), 0, 0, 0, 0, 0, 0, 0}).Ok {
+		t.Log("reject retained failure")
+		t.FailNow()
+	}
+
+	// a version copied in place is rejected while truncated and
+	// reloaded once the copy is complete with the same header
+	insert([]byte{byte(size + 1), 0, 0, 0, 0, 0, 0, 0})
+	kn.Write(path + ".copy")
+	defer os.Remove(path + ".copy")
+	b, _ = os.ReadFile(path + ".copy")
+	os.WriteFile(path, b[:len(b)/2], 0644)
+	t.Log("reject", <-reject)
+	os.WriteFile(path, b, 0644)
+	select {
+	case count := <-reload:
+		if count != 52 || !h.Lookup([]byte{byte(size + 1), 0, 0, 0, 0, 0, 0, 0}).Ok {
+			t.Log("copied reload failure", count)
+			t.FailNow()
+		}
+	case <-time.After(time.Second):
+		t.Log("copied reload timeout")
+		t.FailNow()
+	}
+
+}
+
+// go test -v -run Verify
+func TestVerify(t *testing.T) {
+
+	os.Mkdir("sandbox", 0755)
+	path := "sandbox/verify.keva"
+	defer os.Remove(path)
+
+	size := uint64(100)
+	kn := kvs.NewKEVA(size, nil)
+	insert := kn.Insert(false)
+	for i := uint64(0); i < size; i++ {
+		insert([]byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0}, i+1)
+	}
+	kn.Write(path)
+
+	if r := kvs.Verify(path); !r.Ok || r.Slots != size {
+		t.Log("verify failure", r)
+		t.FailNow()
+	}
+
+	// clear the first key in the first full bucket which
+	// leaves a gap, an orphan value, and a bad checksum
+	b, _ := os.ReadFile(path)
+	for n := 80; n < len(b); n += 16 * 3 {
+		if binary.BigEndian.Uint64(b[n+32:]) != 0 {
+			binary.BigEndian.PutUint64(b[n:], 0)
+			break
+		}
+	}
+	os.WriteFile(path, b, 0644)
+
+	r := kvs.Verify(path)
+	if r.Ok || r.Gaps != 1 || r.Orphans != 1 || r.Checksum == r.Computed || r.Slots != size-1 {
+		t.Log("corruption not detected", r)
+		t.FailNow()
+	}
+	t.Log("verify", r.Gaps, r.Orphans, r.Slots)
+
+}
+
+// go test -v -run KEVAShuffle
+func TestKEVAShuffle(t *testing.T) {
+
+	path := "sandbox/shuffle.keva"
+	defer os.Remove(path)
+
+	// a displaced key tries all of its rows, as with KEON, since a row
+	// left unchecked can later take a swap into an empty mid-bucket slot;
+	// full tables that need the insert shuffle must leave no gaps
+	size := uint64(100)
+	for trial := 0; trial < 50; trial++ {
+		kn := kvs.NewKEVA(size, nil)
+		insert := kn.Insert(false)
+		for i := uint64(0); i < size; i++ {
+			insert([]byte{byte(i + 1), byte(trial), 0, 0, 0, 0, 0, 0}, i+1)
+		}
+		kn.Write(path)
+		if r := kvs.Verify(path); !r.Ok || r.Gaps != 0 {
+			t.Log("shuffle gap failure", trial, r)
+			t.FailNow()
+		}
+	}
+
+}
+
+// go test -v -run Stage
+func TestStage(t *testing.T) {
+
+	size := uint64(1000)
+	st := kvs.NewStageKEON(100) // spill
+	for i := uint64(0); i < size; i++ {
+		st.Add([]byte{byte(i), byte(i >> 8), 1, 0, 0, 0, 0, 0})
+	}
+	st.Add([]byte{0, 0, 1, 0, 0, 0, 0, 0}) // duplicate
+	kn, err := st.Build(&kvs.Option{Density: 50})
+	if err != nil || kn.Len() != size || kn.Cap() != size+1 {
+		t.Log("stage keon failure", err)
+		t.FailNow()
+	}
+	lookup := kn.Lookup()
+	for i := uint64(0); i < size; i++ {
+		if !lookup([]byte{byte(i), byte(i >> 8), 1, 0, 0, 0, 0, 0}) {
+			t.Log("stage keon lookup failure", i)
+			t.FailNow()
+		}
+	}
+
+	sv := kvs.NewStageKEVA(100)
+	for i := uint64(0); i < size; i++ {
+		sv.Add([]byte{byte(i), byte(i >> 8), 2, 0, 0, 0, 0, 0}, i+1)
+	}
+	sv.Add([]byte{0, 0, 2, 0, 0, 0, 0, 0}, 0) // duplicate; first value retained
+	kv, err := sv.Build(&kvs.Option{Density: 50})
+	if err != nil || kv.Len() != size {
+		t.Log("stage keva failure", err)
+		t.FailNow()
+	}
+	find := kv.Lookup()
+	for i := uint64(0); i < size; i++ {
+		if item := find([]byte{byte(i), byte(i >> 8), 2, 0, 0, 0, 0, 0}); !item.Ok || item.Value != i+1 {
+			t.Log("stage keva lookup failure", i, item)
+			t.FailNow()
+		}
+	}
+
+	if _, err = kvs.NewStageKEON(0).Build(nil); err == nil {
+		t.Log("empty stage failure")
+		t.FailNow()
+	}
+
+}
+
+// go test -v -run AutoTune
+func TestAutoTune(t *testing.T) {
+
+	size := 10000
+	keys := make([][]byte, size)
+	for i := range keys {
+		keys[i] = []byte{byte(i), byte(i >> 8), 3, 0, 0, 0, 0, 0}
+	}
+
+	result := kvs.AutoTune(keys, kvs.Goal{Target: kvs.TuneMemory})
+	if !result.Ok || result.KEON == nil || result.KEON.Len() != uint64(size) {
+		t.Log("autotune failure", result.Trials)
+		t.FailNow()
+	}
+	var memory uint64
+	for _, trial := range result.Trials {
+		if trial.Ok && trial.Option == result.Option {
+			memory = trial.Memory
+		}
+	}
+	for _, trial := range result.Trials {
+		if trial.Ok && trial.Memory < memory {
+			t.Log("autotune memory goal failure", trial, result.Option)
+			t.FailNow()
+		}
+	}
+	t.Logf("memory %+v %d trials", result.Option, len(result.Trials))
+
+	result = kvs.AutoTune(keys, kvs.Goal{Target: kvs.TuneFill, Fill: 0.99})
+	if !result.Ok {
+		t.Log("autotune fill failure")
+		t.FailNow()
+	}
+	for _, trial := range result.Trials {
+		if trial.Option == result.Option && trial.Ok && trial.Fill < 0.99 {
+			t.Log("autotune fill goal failure", trial)
+			t.FailNow()
+		}
+	}
+	t.Logf("fill %+v", result.Option)
+
+}
+
+// go test -v -run Inspect
+func TestInspect(t *testing.T) {
+
+	size := uint64(30)
+	kn := kvs.NewKEVA(size, nil)
+	insert := kn.Insert(false)
+	for i := uint64(0); i < size; i++ {
+		insert([]byte{byte(i + 1), 5, 0, 0, 0, 0, 0, 0}, i+1)
+	}
+
+	var b bytes.Buffer
+	if err := kn.Inspect(&b, &kvs.InspectOption{Grid: true, Occupancy: true, From: 2, To: 4, Key: []byte{1, 5, 0, 0, 0, 0, 0, 0}}); err != nil ||
+		!strings.Contains(b.String(), "found row") || !strings.Contains(b.String(), "rows 2-4 grid") {
+		t.Log("inspect failure", err, b.String())
+		t.FailNow()
+	}
+	t.Log(b.String())
+
+	b.Reset()
+	kn.Inspect(&b, &kvs.InspectOption{Key: []byte("absent")})
+	if !strings.Contains(b.String(), "absent;") {
+		t.Log("inspect absent failure", b.String())
+		t.FailNow()
+	}
+
+}
+
+// go test -v -run HandleUpdate
+func TestHandleUpdate(t *testing.T) {
+
+	os.Mkdir("sandbox", 0755)
+	path := "sandbox/update.keva"
+	defer os.Remove(path)
+
+	kn := kvs.NewKEVA(100, nil)
+	kn.Insert(false)([]byte("a"), 1)
+	kn.Write(path)
+
+	h, err := kvs.NewHandle(path)
+	if err != nil {
+		t.Log("handle failure", err)
+		t.FailNow()
+	}
+	h.Watch(time.Millisecond)
+	defer h.Close()
+
+	// readers are not blocked by an update and see the prior table until
+	// the modified copy is swapped in
+	var inside [2]bool
+	h.Update(func(_ *kvs.KEON, keva *kvs.KEVA) error {
+		keva.Insert(false)([]byte("x"), 9)
+		inside = [2]bool{h.Lookup([]byte("x")).Ok, h.Len() == 1}
+		return nil
+	})
+	if inside != [2]bool{false, true} || h.Lookup([]byte("x")).Value != 9 {
+		t.Log("update copy failure", inside)
+		t.FailNow()
+	}
+	// a failing update discards the copy and leaves the table unchanged
+	if err := h.Update(func(_ *kvs.KEON, keva *kvs.KEVA) error {
+		keva.Remove()([]byte("x"))
+		keva.Insert(false)([]byte("y"), 1)
+		return errors.New("partial")
+	}); err == nil || !h.Lookup([]byte("x")).Ok || h.Lookup([]byte("y")).Ok || h.Len() != 2 {
+		t.Log("update error swap failure", err)
+		t.FailNow()
+	}
+
+	var done = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				h.Lookup([]byte("a"))
+			}
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		h.Update(func(_ *kvs.KEON, keva *kvs.KEVA) error {
+			keva.Insert(false)([]byte{byte(i), 'b'}, uint64(i))
+			return nil
+		})
+	}
+	close(done)
+
+	if err = h.Save(); err != nil || h.Len() != 52 || h.Info().Count != 52 {
+		t.Log("update save failure", err, h.Len(), h.Info().Count)
+		t.FailNow()
+	}
+	time.Sleep(10 * time.Millisecond) // watcher does not reload the saved table
+	if item := h.Lookup([]byte{49, 'b'}); !item.Ok || item.Value != 49 {
+		t.Log("update lookup failure", item)
+		t.FailNow()
+	}
+
+}
+
+// go test -v -run Client
+func TestClient(t *testing.T) {
+
+	kn := kvs.NewKEON(100, nil)
+	insert := kn.Insert(false)
+	for i := 0; i < 50; i++ {
+		insert([]byte{byte(i), 'k'})
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("listen", err)
+	}
+	defer ln.Close()
+
+	// a minimal lookup server of the keon as table 0 named test
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+		lookup := kn.Lookup()
+		var buf []byte
+		var req client.Request
+		for client.ReadRequest(r, &req, &buf) == nil {
+			var resp client.Response
+			switch req.Op {
+			case client.OpTable:
+				resp.Items = append(resp.Items, client.Item{Ok: string(req.Keys[0]) == "test", Value: 0xff01})
+			case client.OpLookup:
+				for _, key := range req.Keys {
+					resp.Items = append(resp.Items, client.Item{Ok: lookup(key)})
+				}
+			default:
+				resp.Err = "unsupported"
+			}
+			client.WriteResponse(w, &resp)
+			if r.Buffered() == 0 {
+				w.Flush()
+			}
+		}
+	}()
+
+	c, err := client.Dial(ln.Addr().String())
+	if err != nil {
+		t.Log("dial failure", err)
+		t.FailNow()
+	}
+	defer c.Close()
+	remote, err := c.KEON("test")
+	if err != nil {
+		t.Log("table failure", err)
+		t.FailNow()
+	}
+
+	// pipelined lookups from concurrent goroutines
+	var done = make(chan bool)
+	for g := 0; g < 4; g++ {
+		go func() {
+			lookup := remote.Lookup()
+			var ok = true
+			for i := 0; i < 100; i++ {
+				ok = ok && lookup([]byte{byte(i), 'k'}) == (i < 50)
+			}
+			done <- ok
+		}()
+	}
+	for g := 0; g < 4; g++ {
+		if !<-done {
+			t.Log("pipelined lookup failure")
+			t.Fail()
+		}
+	}
+
+	found, err := remote.LookupBatch([][]byte{{1, 'k'}, {99, 'k'}})
+	if err != nil || !found[0] || found[1] || c.Err() != nil {
+		t.Log("batch failure", found, err, c.Err())
+		t.FailNow()
+	}
+	if remote.Remove()([]byte{1, 'k'}).Ok || c.Err() == nil {
+		t.Log("server error not reported")
+		t.FailNow()
+	}
+
+}
+
+// go test -v -run Replication
+func TestReplication(t *testing.T) {
+
+	os.Mkdir("sandbox", 0755)
+	primary, replica := "sandbox/primary.keva", "sandbox/replica.keva"
+	defer os.Remove(primary)
+	defer os.Remove(replica)
+
+	kn := kvs.NewKEVA(1000, nil)
+	insert := kn.Insert(false)
+	for i := uint64(0); i < 100; i++ {
+		insert([]byte{byte(i), 'p'}, i)
+	}
+	kn.Write(primary)
+	saved, _ := os.ReadFile(primary)
+	stale := kvs.NewKEVA(10, nil)
+	stale.Insert(false)([]byte("stale"), 1)
+	stale.Write(replica)
+
+	ph, _ := kvs.NewHandle(primary)
+	p, err := kvs.NewPrimary(ph, 50)
+	if err != nil {
+		t.Log("primary failure", err)
+		t.FailNow()
+	}
+	defer p.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("listen", err)
+	}
+	defer ln.Close()
+	go kvs.ServeReplication(ln, map[string]*kvs.Primary{"test": p})
+
+	rh, _ := kvs.NewHandle(replica)
+	r := kvs.NewReplica(rh, ln.Addr().String(), "test")
+	var snapshots = make(chan uint64, 10)
+	r.OnSnapshot = func(seq uint64) { snapshots <- seq }
+	r.OnError = func(err error) { t.Log("replica", err) }
+
+	// wait for the replica to reach the primary sequence
+	var caughtUp = func() bool {
+		for i := 0; i < 500; i++ {
+			if r.Seq() == p.Seq() && rh.Len() == ph.Len() {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+
+	// an empty replica is sent a snapshot and then the batches
+	r.Start()
+	<-snapshots
+	for i := uint64(0); i < 20; i++ {
+		p.Update(func(_ *kvs.KEON, keva *kvs.KEVA) error {
+			keva.Insert(false)([]byte{byte(i), 'b'}, i+1000)
+			return nil
+		})
+	}
+	p.Update(func(_ *kvs.KEON, keva *kvs.KEVA) error {
+		keva.Remove()([]byte{1, 'p'})
+		keva.Remove()([]byte{2, 'p'})
+		keva.Insert(false)([]byte{2, 'p'}, 42) // value update
+		return nil
+	})
+	p.Update(func(_ *kvs.KEON, keva *kvs.KEVA) error {
+		keva.Insert(false)([]byte{0, 'e'}, 1) // discarded with the error
+		return errors.New("failed")
+	})
+	if !caughtUp() || p.Seq() != 23 || rh.Lookup([]byte{19, 'b'}).Value != 1019 || rh.Lookup([]byte{0, 'e'}).Ok ||
+		rh.Lookup([]byte{1, 'p'}).Ok || rh.Lookup([]byte{2, 'p'}).Value != 42 {
+		t.Log("replication failure", r.Seq(), p.Seq(), rh.Len(), ph.Len())
+		t.FailNow()
+	}
+
+	// a replica beyond the backlog is sent a snapshot
+	r.Close()
+	for i := uint64(0); i < 100; i++ {
+		p.Update(func(_ *kvs.KEON, keva *kvs.KEVA) error {
+			keva.Insert(false)([]byte{byte(i), 'c'}, i)
+			return nil
+		})
+	}
+	r.Start()
+	defer r.Close()
+	if seq := <-snapshots; seq != 123 || !caughtUp() || rh.KEVA().Checksum() != ph.KEVA().Checksum() {
+		t.Log("snapshot failure", seq, r.Seq(), p.Seq())
+		t.FailNow()
+	}
+
+	// a snapshot is sent from memory and leaves the primary file alone
+	if b, _ := os.ReadFile(primary); !bytes.Equal(b, saved) || ph.Info().Count != 100 {
+		t.Log("snapshot saved the primary", ph.Info().Count)
+		t.FailNow()
+	}
+
+}
+
+// go test -v -run Sharded
+func TestSharded(t *testing.T) {
+
+	os.Mkdir("sandbox", 0755)
+	path := "sandbox/sharded.kvss"
+	defer func() {
+		files, _ := filepath.Glob("sandbox/sharded*")
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}()
+
+	size := uint64(10000)
+	s := kvs.NewSharded(size, 7, nil)
+	insert := s.Insert(false)
+	var checksum uint64
+	for i := uint64(0); i < size*9/10; i++ {
+		key := binary.BigEndian.AppendUint64(nil, i)
+		if !insert(key).Ok {
+			t.Log("insert failure", i)
+			t.FailNow()
+		}
+		checksum ^= xxhash.Sum(key)
+	}
+
+	// keys are spread across every shard
+	for i := 0; i < s.Shards(); i++ {
+		if n := s.Shard(i).Len(); n < size/7/2 {
+			t.Log("routing imbalance", i, n)
+			t.Fail()
+		}
+	}
+	if s.Len() != size*9/10 || s.Cap() < size || s.Checksum() != checksum {
+		t.Log("stats failure", s.Len(), s.Cap(), s.Checksum(), checksum)
+		t.FailNow()
+	}
+
+	if err := s.Write(path); err != nil {
+		t.Log("write failure", err)
+		t.FailNow()
+	}
+	loaded, err := kvs.LoadSharded(path)
+	if err != nil || loaded.Len() != s.Len() || loaded.Checksum() != checksum {
+		t.Log("load failure", err)
+		t.FailNow()
+	}
+
+	// grow a shard and remove keys from the loaded set
+	if err = loaded.Grow(3, loaded.Shard(3).Cap()*2); err != nil {
+		t.Log("grow failure", err)
+		t.FailNow()
+	}
+	if empty := kvs.NewSharded(100, 4, nil); empty.Grow(0, 0) == nil || empty.Shard(0) == nil {
+		t.Log("grow empty shard to 0 failure")
+		t.FailNow()
+	}
+	lookup, remove := loaded.Lookup(), loaded.Remove()
+	for i := uint64(0); i < size; i++ {
+		key := binary.BigEndian.AppendUint64(nil, i)
+		if lookup(key) != (i < size*9/10) {
+			t.Log("lookup failure", i)
+			t.FailNow()
+		}
+		if i%2 == 0 && remove(key).Exist {
+			checksum ^= xxhash.Sum(key)
+		}
+	}
+	if loaded.Len() != size*9/10/2 || loaded.Checksum() != checksum {
+		t.Log("remove failure", loaded.Len(), loaded.Checksum(), checksum)
+		t.FailNow()
+	}
+
+	// a shard that does not match the manifest is rejected
+	kvs.NewKEON(10, nil).Write("sandbox/sharded.0002.keon")
+	if _, err = kvs.LoadSharded(path); err == nil {
+		t.Log("stale shard not detected")
+		t.FailNow()
+	}
+
+}
+
+// go test -v -run Metrics
+func TestMetrics(t *testing.T) {
+
+	os.Mkdir("sandbox", 0755)
+	path := "sandbox/metrics.keon"
+	defer os.Remove(path)
+
+	m := metrics.New()
+	kn := kvs.NewKEON(100, nil)
+	kn.SetMetrics("block", m)
+	insert := kn.Insert(false)
+	for i := 0; i < 90; i++ {
+		insert([]byte{byte(i), 'k'})
+	}
+	insert([]byte{0, 'k'})
+	if err := kn.Write(path); err != nil {
+		t.Log("write failure", err)
+		t.FailNow()
+	}
+
+	h, err := kvs.NewHandle(path)
+	if err != nil {
+		t.Log("handle failure", err)
+		t.FailNow()
+	}
+	h.SetMetrics("block", m)
+	m.Size("block", func() (uint64, uint64) { return h.Len(), h.Info().Max })
+	h.Lookup([]byte{1, 'k'})
+	h.Lookup([]byte("missing"))
+	f, _ := os.OpenFile(path, os.O_WRONLY, 0644)
+	f.WriteAt([]byte{0xff}, 15) // corrupt the checksum
+	f.Close()
+	if h.Reload() == nil {
+		t.Log("corrupt table reloaded")
+		t.FailNow()
+	}
+
+	var b bytes.Buffer
+	m.WriteText(&b)
+	for _, line := range []string{
+		`kvs_lookups_total{table="block",result="hit"} 1`,
+		`kvs_lookups_total{table="block",result="miss"} 1`,
+		`kvs_inserts_total{table="block",outcome="ok"} 90`,
+		`kvs_inserts_total{table="block",outcome="exist"} 1`,
+		`kvs_insert_swaps_count{table="block"} 90`,
+		`kvs_count{table="block"} 90`,
+		`kvs_fill_ratio{table="block"} 0.9`,
+		`kvs_load_duration_seconds_count{table="block"} 1`,
+		`kvs_checksum_failures_total{table="block"} 1`,
+		`kvs_save_duration_seconds_count{table="block"} 1`,
+		`kvs_save_errors_total{table="block"} 0`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Log("metrics missing", line)
+			t.Log(b.String())
+			t.FailNow()
+		}
+	}
+
+}
+
+// go test -v -run Stats
+func TestStats(t *testing.T) {
+
+	kn := kvs.NewKEON(10000, &kvs.Option{Density: 50})
+	insert := kn.Insert(false)
+	var b [8]byte
+	for i := uint64(0); i < 10000; i++ {
+		binary.BigEndian.PutUint64(b[:], i)
+		if !insert(b[:]).Ok {
+			t.Log("insert failure", i)
+			t.FailNow()
+		}
+	}
+	insert([]byte("full"))
+
+	s := kn.Stats()
+	var swaps uint64
+	for _, n := range s.Swaps {
+		swaps += n
+	}
+	if s.Direct+s.Displaced != kn.Len() || swaps != s.Displaced || s.Full != 1 || s.Exhausted != 0 {
+		t.Log("keon stats failure", s)
+		t.FailNow()
+	}
+	if s.Displaced > 0 && (s.Onset <= 0 || s.Onset >= 1) {
+		t.Log("keon onset failure", s.Onset)
+		t.FailNow()
+	}
+
+	kv := kvs.NewKEVA(10000, &kvs.Option{Density: 50})
+	insertKV := kv.Insert(false)
+	for i := uint64(0); i < 10000; i++ {
+		binary.BigEndian.PutUint64(b[:], i)
+		insertKV(b[:], i)
+	}
+	s = kv.Stats()
+	if s.Direct+s.Displaced != kv.Len() || s.Full != 0 {
+		t.Log("keva stats failure", s)
+		t.FailNow()
+	}
+
+	// a shuffler that aborts every track exhausts without a displacement
+	kn = kvs.NewKEON(100, &kvs.Option{Density: 1000, Width: 1, Shuffler: 1, Tracker: 1})
+	insert = kn.Insert(false)
+	for i := uint64(0); i < 100; i++ {
+		binary.BigEndian.PutUint64(b[:], i)
+		insert(b[:])
+	}
+	if s = kn.Stats(); s.Exhausted == 0 || s.Displaced != 0 || s.Onset != 0 {
+		t.Log("exhausted onset failure", s)
+		t.FailNow()
+	}
+
+}
+
+// go test -v -run Analyze
+func TestAnalyze(t *testing.T) {
+
+	kn := kvs.NewKEON(10000, &kvs.Option{Density: 50})
+	insert := kn.Insert(false)
+	for i := 0; i < 5000; i++ {
+		insert([]byte{byte(i >> 8), byte(i), 'k'})
+	}
+	checksum := kn.Checksum()
+
+	a := kn.Analyze()
+	var rows, keys uint64
+	for used, n := range a.Fill {
+		rows += n
+		keys += uint64(used) * n
+	}
+	if rows != a.Depth || keys != 5000 || a.Row[0]+a.Row[1]+a.Row[2] != 5000 || a.Misplaced != 0 {
+		t.Log("analyze placement failure", a.Fill, a.Row, a.Misplaced)
+		t.FailNow()
+	}
+	if a.ProbeMiss != 9 || a.ProbeHit < 1 || a.ProbeHit > 9 {
+		t.Log("analyze probe failure", a.ProbeHit, a.ProbeMiss)
+		t.FailNow()
+	}
+	if a.Headroom == 0 || a.Headroom > 5000 || kn.Len() != 5000 || kn.Checksum() != checksum {
+		t.Log("analyze headroom failure", a.Headroom, kn.Len())
+		t.FailNow()
+	}
+	t.Log(a.Row, a.FullRun, a.ProbeHit, a.Headroom)
+
+	kv := kvs.NewKEVA(100, nil)
+	kv.Insert(false)([]byte("a"), 1)
+	if a = kv.Analyze(); a.Count != 1 || a.Row[0]+a.Row[1]+a.Row[2] != 1 || a.Headroom == 0 {
+		t.Log("keva analyze failure", a)
+		t.FailNow()
+	}
+
+	// the trial inserts are bounded on a table with large spare capacity
+	kn = kvs.NewKEON(1000000, nil)
+	kn.Insert(false)([]byte("a"))
+	if a = kn.Analyze(); a.Headroom != kn.Cap()-1 {
+		t.Log("bounded headroom failure", a.Headroom, kn.Cap())
+		t.FailNow()
+	}
+
+}
+
+// go test -v -run Context
+func TestContext(t *testing.T) {
+
+	os.Mkdir("sandbox", 0755)
+	path := "sandbox/context.keon"
+	defer os.Remove(path)
+
+	st := kvs.NewStageKEON(0)
+	for i := 0; i < 200000; i++ {
+		st.Add([]byte{byte(i >> 16), byte(i >> 8), byte(i)})
+	}
+	var last [2]uint64
+	kn, err := st.BuildContext(context.Background(), &kvs.Option{Density: 50}, func(done, total uint64, _ time.Duration) {
+		last = [2]uint64{done, total}
+	})
+	if err != nil || last != [2]uint64{200000, 200000} {
+		t.Log("build failure", err, last)
+		t.FailNow()
+	}
+	if err = kn.WriteContext(context.Background(), path, nil); err != nil {
+		t.Log("write failure", err)
+		t.FailNow()
+	}
+
+	// a cancelled save leaves the prior file in place
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	kn.Insert(false)([]byte("new"))
+	if err = kn.SaveContext(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Log("cancelled save failure", err)
+		t.FailNow()
+	}
+	if _, err = os.Stat(path + ".tmp"); err == nil || kvs.Info(path).Count != 200000 {
+		t.Log("cancelled save replaced the file")
+		t.FailNow()
+	}
+
+	if _, err = kvs.LoadKEONContext(ctx, path, nil); !errors.Is(err, context.Canceled) {
+		t.Log("cancelled load failure", err)
+		t.FailNow()
+	}
+	if kn, err = kvs.LoadKEONContext(context.Background(), path, nil); err != nil || kn.Len() != 200000 {
+		t.Log("load failure", err)
+		t.FailNow()
+	}
+
+	if _, _, err = kvs.MergeContext(ctx, []string{path}, nil, nil); !errors.Is(err, context.Canceled) {
+		t.Log("cancelled merge failure", err)
+		t.FailNow()
+	}
+	st = kvs.NewStageKEON(0)
+	st.Add([]byte("a"))
+	if _, err = st.BuildContext(ctx, nil, nil); !errors.Is(err, context.Canceled) {
+		t.Log("cancelled build failure", err)
+		t.FailNow()
+	}
+
+}
+
+// go test -v -run Chunks
+func TestChunks(t *testing.T) {
+
+	os.Mkdir("sandbox", 0755)
+	path := "sandbox/chunks.keva"
+	defer os.Remove(path)
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	// several chunks with a partial last chunk and a parallel checksum
+	kn := kvs.NewKEVA(1200000, &kvs.Option{Density: 50})
+	insert := kn.Insert(false)
+	var k [4]byte
+	for i := uint64(0); i < 1000000; i++ {
+		binary.BigEndian.PutUint32(k[:], uint32(i))
+		if !insert(k[:], i).Ok {
+			t.Log("insert failure", i)
+			t.FailNow()
+		}
+	}
+	var last [2]uint64
+	if err := kn.WriteContext(context.Background(), path, func(done, total uint64, _ time.Duration) {
+		last = [2]uint64{done, total}
+	}); err != nil || last[0] != last[1] || last[0] < 1000000 {
+		t.Log("write failure", err, last)
+		t.FailNow()
+	}
+
+	kv, err := kvs.LoadKEVAContext(context.Background(), path, nil)
+	if err != nil || kv.Len() != 1000000 || kv.Checksum() != kn.Checksum() {
+		t.Log("load failure", err)
+		t.FailNow()
+	}
+	lookup := kv.Lookup()
+	for i := uint64(0); i < 1000000; i += 997 {
+		binary.BigEndian.PutUint32(k[:], uint32(i))
+		if item := lookup(k[:]); !item.Ok || item.Value != i {
+			t.Log("lookup failure", i, item)
+			t.FailNow()
+		}
+	}
+
+	// a short file fails the checksum
+	fi, _ := os.Stat(path)
+	os.Truncate(path, fi.Size()/2)
+	if _, err = kvs.LoadKEVAContext(context.Background(), path, nil); err == nil {
+		t.Log("short file loaded")
+		t.FailNow()
+	}
+
+}
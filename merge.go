@@ -1,10 +1,15 @@
 package kvs
 
 import (
+	"bufio"
+	"context"
 	"encoding/binary"
 	"errors"
 	"io"
 	"os"
+	"runtime"
+	"sort"
+	"sync"
 )
 
 // MergeKEON current KEON with another
@@ -191,3 +196,484 @@ func MergeKEVA(dst *KEVA, path string, action interface{}) (result struct {
 
 	return
 }
+
+// MergeFrom merges the src *KEON into the current *KEON directly
+// from memory without the need to write the src to disk first
+//
+//	action nil,true  insert
+//	action false     remove
+func (kn *KEON) MergeFrom(src *KEON, action interface{}) (result struct {
+	Ok, Invalid, NoSpace bool
+	Items, Checksum      uint64
+}) {
+
+	var current = kn.Checksum()
+
+	// valid source with content and available space
+	result.Invalid = src == nil || src.count == 0
+	result.NoSpace = !result.Invalid && kn.count+src.count > kn.max
+	result.Ok = !result.Invalid && !result.NoSpace
+	if result.Ok {
+
+		var b [8]byte
+
+		if action == nil || action.(bool) {
+
+			// use an assurance that we can only add new items
+			// so that we can track the new items
+			insert := kn.RawInsert(false)
+			for _, k := range src.key {
+				if k != 0 {
+					binary.BigEndian.PutUint64(b[:], k)
+					r := insert(b[:])
+					if r.Exist {
+						continue
+					}
+					if r.NoSpace {
+						// the current format can not support the
+						// new additional keys; insert failed
+						result.Ok = false
+						result.NoSpace = true
+						return
+					}
+					if !r.Ok {
+						break
+					}
+					result.Checksum ^= k
+					result.Items++
+				}
+			}
+			result.Ok = kn.Checksum() == current^result.Checksum
+
+		} else {
+
+			remove := kn.RawRemove()
+			for _, k := range src.key {
+				if k != 0 {
+					binary.BigEndian.PutUint64(b[:], k)
+					if remove(b[:]).Exist {
+						result.Checksum ^= k
+						result.Items++
+					}
+				}
+			}
+			result.Ok = kn.Checksum() == current^result.Checksum
+
+		}
+	}
+
+	return
+}
+
+// MergeFromParallel merges the src *KEON into the current *KEON using
+// workers to scan the src buckets and filter the src keys against the
+// current *KEON concurrently (reads only) so that only keys requiring
+// a change are applied serially; the space pre-check is exact
+//
+//	action nil,true  insert
+//	action false     remove
+func (kn *KEON) MergeFromParallel(src *KEON, action interface{}, workers int) (result struct {
+	Ok, Invalid, NoSpace bool
+	Items, Checksum      uint64
+}) {
+
+	var current = kn.Checksum()
+	var insert = action == nil || action.(bool)
+
+	result.Invalid = src == nil || src.count == 0
+	if result.Invalid {
+		return
+	}
+
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	// each worker scans a segment of the src buckets and keeps the keys
+	// that are absent (insert) or present (remove) in the current *KEON
+	var keys = make([][]uint64, workers)
+	var wg sync.WaitGroup
+	var segment = uint64(len(src.key))/uint64(workers) + 1
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			start := uint64(w) * segment
+			end := start + segment
+			if start > uint64(len(src.key)) {
+				return
+			}
+			if end > uint64(len(src.key)) {
+				end = uint64(len(src.key))
+			}
+			for _, k := range src.key[start:end] {
+				if k != 0 && kn.find(k) != insert {
+					keys[w] = append(keys[w], k)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if insert {
+		var items uint64
+		for w := range keys {
+			items += uint64(len(keys[w]))
+		}
+		result.NoSpace = kn.count+items > kn.max
+		if result.NoSpace {
+			return
+		}
+	}
+
+	var b [8]byte
+	var ok = true
+	var rawInsert = kn.RawInsert(false)
+	var rawRemove = kn.RawRemove()
+	for w := range keys {
+		for _, k := range keys[w] {
+			binary.BigEndian.PutUint64(b[:], k)
+			if insert {
+				r := rawInsert(b[:])
+				if r.NoSpace {
+					// the current format can not support the
+					// new additional keys; insert failed
+					result.NoSpace = true
+					return
+				}
+				ok = r.Ok
+			} else {
+				ok = rawRemove(b[:]).Exist
+			}
+			if ok {
+				result.Checksum ^= k
+				result.Items++
+			}
+		}
+	}
+	result.Ok = kn.Checksum() == current^result.Checksum
+
+	return
+}
+
+// MergeFrom merges the src *KEVA into the current *KEVA directly
+// from memory without the need to write the src to disk first
+//
+//	action nil,true  insert
+//	action false     remove
+func (kn *KEVA) MergeFrom(src *KEVA, action interface{}) (result struct {
+	Ok, Invalid, NoSpace bool
+	Items, Checksum      uint64
+}) {
+
+	var current = kn.Checksum()
+
+	// valid source with content and available space
+	result.Invalid = src == nil || src.count == 0
+	result.NoSpace = !result.Invalid && kn.count+src.count > kn.max
+	result.Ok = !result.Invalid && !result.NoSpace
+	if result.Ok {
+
+		var b [8]byte
+
+		if action == nil || action.(bool) {
+
+			// we allow updates but keep track of the
+			// updated items for our new checksum
+			insert := kn.RawInsert(true)
+			for i, k := range src.key {
+				if k != 0 {
+					binary.BigEndian.PutUint64(b[:], k)
+					r := insert(b[:], src.value[i])
+					if r.Exist {
+						continue
+					}
+					if r.NoSpace {
+						// the current format can not support the
+						// new additional keys; insert failed
+						result.Ok = false
+						result.NoSpace = true
+						return
+					}
+					if !r.Ok {
+						break
+					}
+					result.Checksum ^= k
+					result.Items++
+				}
+			}
+			result.Ok = kn.Checksum() == current^result.Checksum
+
+		} else {
+
+			remove := kn.RawRemove()
+			for _, k := range src.key {
+				if k != 0 {
+					binary.BigEndian.PutUint64(b[:], k)
+					if remove(b[:]).Exist {
+						result.Checksum ^= k
+						result.Items++
+					}
+				}
+			}
+			result.Ok = kn.Checksum() == current^result.Checksum
+
+		}
+	}
+
+	return
+}
+
+// MergeFromParallel merges the src *KEVA into the current *KEVA using
+// workers to scan the src buckets and filter the src keys against the
+// current *KEVA concurrently (reads only) so that only keys requiring
+// a change are applied serially; the space pre-check is exact
+//
+//	action nil,true  insert
+//	action false     remove
+func (kn *KEVA) MergeFromParallel(src *KEVA, action interface{}, workers int) (result struct {
+	Ok, Invalid, NoSpace bool
+	Items, Checksum      uint64
+}) {
+
+	var current = kn.Checksum()
+	var insert = action == nil || action.(bool)
+
+	result.Invalid = src == nil || src.count == 0
+	if result.Invalid {
+		return
+	}
+
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	// each worker scans a segment of the src buckets and keeps the slots
+	// that are absent (insert) or present (remove) in the current *KEVA
+	var slots = make([][]uint64, workers)
+	var wg sync.WaitGroup
+	var segment = uint64(len(src.key))/uint64(workers) + 1
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			start := uint64(w) * segment
+			end := start + segment
+			if start > uint64(len(src.key)) {
+				return
+			}
+			if end > uint64(len(src.key)) {
+				end = uint64(len(src.key))
+			}
+			for i := start; i < end; i++ {
+				if src.key[i] != 0 {
+					if _, ok := kn.find(src.key[i]); ok != insert {
+						slots[w] = append(slots[w], i)
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if insert {
+		var items uint64
+		for w := range slots {
+			items += uint64(len(slots[w]))
+		}
+		result.NoSpace = kn.count+items > kn.max
+		if result.NoSpace {
+			return
+		}
+	}
+
+	var b [8]byte
+	var ok = true
+	var rawInsert = kn.RawInsert(false)
+	var rawRemove = kn.RawRemove()
+	for w := range slots {
+		for _, i := range slots[w] {
+			binary.BigEndian.PutUint64(b[:], src.key[i])
+			if insert {
+				r := rawInsert(b[:], src.value[i])
+				if r.NoSpace {
+					// the current format can not support the
+					// new additional keys; insert failed
+					result.NoSpace = true
+					return
+				}
+				ok = r.Ok
+			} else {
+				ok = rawRemove(b[:]).Exist
+			}
+			if ok {
+				result.Checksum ^= src.key[i]
+				result.Items++
+			}
+		}
+	}
+	result.Ok = kn.Checksum() == current^result.Checksum
+
+	return
+}
+
+// MergeFiles combines many *KEON files into a new *KEON that is sized from the
+// de-duplicated combined count of all the sources so that it can not fill up
+// part way through; each source checksum is verified while it is streamed and
+// the composite checksum is verified once all the sources have been merged.
+// The keys of every source are held in memory, one uint64 per source key,
+// in addition to the new table; the partial table is returned with NoSpace
+// so that its Cap and Stats can be reported.
+//
+//	Sources  per source statistics in paths order
+//	  Ok       signature, checksum, and count verified
+//	  Count    items in source
+//	  Items    new items contributed by source
+func MergeFiles(paths []string, opt *Option) (kn *KEON, result struct {
+	Ok, Invalid, NoSpace bool
+	Items, Checksum      uint64
+	Sources              []struct {
+		Path                   string
+		Ok                     bool
+		Count, Items, Checksum uint64
+	}
+}) {
+	kn, result, _ = MergeContext(context.Background(), paths, opt, nil)
+	return
+}
+
+// MergeContext is MergeFiles that stops with the context error when the
+// context is done and reports the source keys inserted to the optional
+// progress once every source has been read; no table is returned with
+// the error.
+func MergeContext(ctx context.Context, paths []string, opt *Option, progress Progress) (kn *KEON, result struct {
+	Ok, Invalid, NoSpace bool
+	Items, Checksum      uint64
+	Sources              []struct {
+		Path                   string
+		Ok                     bool
+		Count, Items, Checksum uint64
+	}
+}, err error) {
+
+	result.Sources = make([]struct {
+		Path                   string
+		Ok                     bool
+		Count, Items, Checksum uint64
+	}, len(paths))
+
+	// stream every source and retain its keys, 8 bytes per source key,
+	// to allow the exact de-duplicated sizing
+	var keys = make([][]uint64, len(paths))
+	var total int
+	for i := range paths {
+		if err = ctx.Err(); err != nil {
+			return nil, result, err
+		}
+		src := &result.Sources[i]
+		src.Path = paths[i]
+		keys[i], src.Ok = mergeRead(paths[i], &src.Count, &src.Checksum)
+		result.Invalid = result.Invalid || !src.Ok
+		total += len(keys[i])
+	}
+	if result.Invalid || total == 0 {
+		result.Invalid = true
+		return
+	}
+
+	// sort each source in place and de-duplicate the sources in a k-way
+	// merge for the composite size requirement and the composite checksum
+	for i := range keys {
+		sort.Slice(keys[i], func(a, b int) bool { return keys[i][a] < keys[i][b] })
+	}
+	var n, composite uint64
+	var next = make([]int, len(keys))
+	for last, first := uint64(0), true; ; first = false {
+		var min, from = uint64(0), -1
+		for i := range keys {
+			if next[i] < len(keys[i]) && (from < 0 || keys[i][next[i]] < min) {
+				min, from = keys[i][next[i]], i
+			}
+		}
+		if from < 0 {
+			break
+		}
+		next[from]++
+		if first || min != last {
+			composite ^= min
+			n++
+		}
+		last = min
+	}
+
+	kn = NewKEON(n, opt)
+	var b [8]byte
+	var done uint64
+	var m = newMeter(ctx, uint64(total), progress)
+	insert := kn.RawInsert(false)
+	for i := range keys {
+		for _, k := range keys[i] {
+			done++
+			if err = m.step(done); err != nil {
+				return nil, result, err
+			}
+			binary.BigEndian.PutUint64(b[:], k)
+			r := insert(b[:])
+			if r.NoSpace {
+				if kn.count == kn.max && kn.find(k) {
+					continue // a full table reports NoSpace before Exist
+				}
+				// shuffler failure; the options do
+				// not support the composite table
+				result.NoSpace = true
+				return
+			}
+			if r.Ok {
+				result.Sources[i].Items++
+				result.Checksum ^= k
+				result.Items++
+			}
+		}
+		keys[i] = nil
+	}
+	if err = m.done(done); err != nil {
+		return nil, result, err
+	}
+
+	result.Ok = result.Checksum == composite && kn.Checksum() == composite
+	return
+}
+
+// mergeRead streams the *KEON file at path and returns the non-zero keys
+// and reports when the signature, header checksum, and count are valid
+func mergeRead(path string, count, checksum *uint64) (keys []uint64, ok bool) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	var header [80]byte
+	var b [8]byte
+	var buf = bufio.NewReader(f)
+	if _, err = io.ReadFull(buf, header[:]); err != nil || binary.BigEndian.Uint64(header[:8]) != 0xff01 {
+		return nil, false
+	}
+	*checksum = binary.BigEndian.Uint64(header[8:16])
+	*count = binary.BigEndian.Uint64(header[24:32])
+
+	var k, current uint64
+	keys = make([]uint64, 0, *count)
+	for {
+		if _, err = io.ReadFull(buf, b[:]); err != nil {
+			// io.EOF or io.UnexpectedEOF
+			return keys, current == *checksum && uint64(len(keys)) == *count
+		}
+		if k = binary.BigEndian.Uint64(b[:]); k != 0 {
+			keys = append(keys, k)
+			current ^= k
+		}
+	}
+
+}
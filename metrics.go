@@ -0,0 +1,44 @@
+package kvs
+
+import "time"
+
+/*
+	METRICS is an optional hook that reports the events of a named table
+	to an implementation such as the Prometheus text exposition of the
+	github.com/zxdev/kvs/metrics package; the core package only calls
+	the interface, and closures made while no hook is set are unchanged
+
+	m := metrics.New()
+	kn.SetMetrics("block", m) // before making the closures
+	h.SetMetrics("block", m)  // or on a handle; follows reloads
+
+	Lookup  every lookup and whether the key was found
+	Insert  every insert result and the shuffle swaps it made
+	Load    every handle load with its duration; !ok is a failed checksum
+	Save    every table save with its duration and result
+
+*/
+
+// Metrics receives the table events; an implementation must be safe for
+// concurrent use as lookups are reported from concurrent readers
+type Metrics interface {
+	Lookup(table string, hit bool)
+	Insert(table string, item struct{ Ok, Exist, NoSpace bool }, swaps uint64)
+	Load(table string, d time.Duration, ok bool)
+	Save(table string, d time.Duration, err error)
+}
+
+// SetMetrics reports the *KEON events as table to m, or stops reporting
+// when m is nil; only closures made after SetMetrics report lookups and inserts.
+func (kn *KEON) SetMetrics(table string, m Metrics) { kn.name, kn.metrics = table, m }
+
+// SetMetrics reports the *KEVA events as table to m, or stops reporting
+// when m is nil; only closures made after SetMetrics report lookups and inserts.
+func (kn *KEVA) SetMetrics(table string, m Metrics) { kn.name, kn.metrics = table, m }
+
+// saved reports a save that started at start
+func saved(m Metrics, table string, start time.Time, err error) {
+	if m != nil {
+		m.Save(table, time.Since(start), err)
+	}
+}
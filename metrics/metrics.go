@@ -0,0 +1,264 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zxdev/kvs"
+)
+
+/*
+	METRICS is a kvs.Metrics implementation that keeps the table events
+	as counters and histograms and writes them in the Prometheus text
+	exposition format without a dependency on the Prometheus client
+
+	m := metrics.New()
+	h.SetMetrics("block", m)
+	m.Size("block", func() (count, max uint64) { return h.Len(), h.Info().Max })
+	http.Handle("/metrics", m)
+
+	kvs_lookups_total{table,result}         counter   hit or miss
+	kvs_inserts_total{table,outcome}        counter   ok, exist, or nospace
+	kvs_insert_swaps{table}                 histogram shuffle swaps per new key
+	kvs_count{table}                        gauge     with a Size function
+	kvs_capacity{table}                     gauge     with a Size function
+	kvs_fill_ratio{table}                   gauge     count / capacity
+	kvs_load_duration_seconds{table}        histogram validated loads
+	kvs_checksum_failures_total{table}      counter   loads that failed validation
+	kvs_save_duration_seconds{table}        histogram saves
+	kvs_save_errors_total{table}            counter   saves that failed
+
+*/
+
+// histogram bucket upper bounds
+var (
+	swapBounds     = []float64{0, 1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024, 4096, 16384}
+	durationBounds = []float64{0.001, 0.01, 0.1, 0.5, 1, 5, 10, 30, 60, 300}
+)
+
+// Prometheus collects the events of any number of named tables; it is
+// safe for concurrent use
+type Prometheus struct {
+	tables sync.Map // *table by name
+}
+
+// table is the metrics of a named table
+type table struct {
+	hits, misses       atomic.Uint64
+	ok, exist, noSpace atomic.Uint64
+	failures, errors   atomic.Uint64
+	swaps, load, save  histogram
+	size               atomic.Pointer[func() (count, max uint64)]
+}
+
+// histogram counts observations by bucket upper bound
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64 // per bucket and +Inf
+	sum    float64
+}
+
+var _ kvs.Metrics = (*Prometheus)(nil)
+
+// New is the *Prometheus constructor.
+func New() *Prometheus { return new(Prometheus) }
+
+// table is the metrics of name
+func (p *Prometheus) table(name string) *table {
+	if t, ok := p.tables.Load(name); ok {
+		return t.(*table)
+	}
+	t, _ := p.tables.LoadOrStore(name, &table{
+		swaps: histogram{bounds: swapBounds},
+		load:  histogram{bounds: durationBounds},
+		save:  histogram{bounds: durationBounds},
+	})
+	return t.(*table)
+}
+
+// Lookup counts a lookup hit or miss.
+func (p *Prometheus) Lookup(table string, hit bool) {
+	if hit {
+		p.table(table).hits.Add(1)
+	} else {
+		p.table(table).misses.Add(1)
+	}
+}
+
+// Insert counts an insert by outcome and observes the shuffle swaps of
+// a new key.
+func (p *Prometheus) Insert(table string, item struct{ Ok, Exist, NoSpace bool }, swaps uint64) {
+	t := p.table(table)
+	switch {
+	case item.Exist:
+		t.exist.Add(1)
+	case item.NoSpace:
+		t.noSpace.Add(1)
+		if swaps > 0 { // shuffler exhausted rather than full
+			t.swaps.observe(float64(swaps))
+		}
+	default:
+		t.ok.Add(1)
+		t.swaps.observe(float64(swaps))
+	}
+}
+
+// Load observes a validated load or counts a checksum failure.
+func (p *Prometheus) Load(table string, d time.Duration, ok bool) {
+	t := p.table(table)
+	if !ok {
+		t.failures.Add(1)
+		return
+	}
+	t.load.observe(d.Seconds())
+}
+
+// Save observes a save or counts a failed save.
+func (p *Prometheus) Save(table string, d time.Duration, err error) {
+	t := p.table(table)
+	if err != nil {
+		t.errors.Add(1)
+		return
+	}
+	t.save.observe(d.Seconds())
+}
+
+// Size sets the function that reports the count and capacity of the
+// table for the count, capacity, and fill ratio gauges.
+func (p *Prometheus) Size(table string, size func() (count, max uint64)) {
+	p.table(table).size.Store(&size)
+}
+
+// observe v
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	if h.counts == nil {
+		h.counts = make([]uint64, len(h.bounds)+1)
+	}
+	i := sort.SearchFloat64s(h.bounds, v) // first bound >= v, or +Inf
+	h.counts[i]++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// ServeHTTP writes the metrics in the text exposition format.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteText(w)
+}
+
+// WriteText writes the metrics of every table in the text exposition format.
+func (p *Prometheus) WriteText(w io.Writer) error {
+
+	var names []string
+	var tables = make(map[string]*table)
+	p.tables.Range(func(k, v interface{}) bool {
+		names = append(names, k.(string))
+		tables[k.(string)] = v.(*table)
+		return true
+	})
+	sort.Strings(names)
+
+	var sizes = make(map[string][2]uint64)
+	for _, name := range names {
+		if size := tables[name].size.Load(); size != nil {
+			count, max := (*size)()
+			sizes[name] = [2]uint64{count, max}
+		}
+	}
+
+	var buf = bufio.NewWriter(w)
+	var family = func(name, kind, help string) {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	var sample = func(name, labels string, v float64) {
+		fmt.Fprintf(buf, "%s{%s} %s\n", name, labels, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	var each = func(fn func(label string, t *table)) {
+		for _, name := range names {
+			fn("table="+quote(name), tables[name])
+		}
+	}
+
+	family("kvs_lookups_total", "counter", "Table lookups by result.")
+	each(func(l string, t *table) {
+		sample("kvs_lookups_total", l+`,result="hit"`, float64(t.hits.Load()))
+		sample("kvs_lookups_total", l+`,result="miss"`, float64(t.misses.Load()))
+	})
+	family("kvs_inserts_total", "counter", "Table inserts by outcome.")
+	each(func(l string, t *table) {
+		sample("kvs_inserts_total", l+`,outcome="ok"`, float64(t.ok.Load()))
+		sample("kvs_inserts_total", l+`,outcome="exist"`, float64(t.exist.Load()))
+		sample("kvs_inserts_total", l+`,outcome="nospace"`, float64(t.noSpace.Load()))
+	})
+	family("kvs_insert_swaps", "histogram", "Shuffle swaps per insert of a new key.")
+	each(func(l string, t *table) { t.swaps.write(buf, "kvs_insert_swaps", l) })
+
+	for _, gauge := range []struct {
+		name, help string
+		value      func(count, max uint64) float64
+	}{
+		{"kvs_count", "Table key count.", func(count, max uint64) float64 { return float64(count) }},
+		{"kvs_capacity", "Table key capacity.", func(count, max uint64) float64 { return float64(max) }},
+		{"kvs_fill_ratio", "Table count over capacity.", func(count, max uint64) float64 {
+			if max == 0 {
+				return 0
+			}
+			return float64(count) / float64(max)
+		}},
+	} {
+		if len(sizes) == 0 {
+			break
+		}
+		family(gauge.name, "gauge", gauge.help)
+		for _, name := range names {
+			if size, ok := sizes[name]; ok {
+				sample(gauge.name, "table="+quote(name), gauge.value(size[0], size[1]))
+			}
+		}
+	}
+
+	family("kvs_load_duration_seconds", "histogram", "Validated table load durations.")
+	each(func(l string, t *table) { t.load.write(buf, "kvs_load_duration_seconds", l) })
+	family("kvs_checksum_failures_total", "counter", "Table loads that failed validation.")
+	each(func(l string, t *table) { sample("kvs_checksum_failures_total", l, float64(t.failures.Load())) })
+	family("kvs_save_duration_seconds", "histogram", "Table save durations.")
+	each(func(l string, t *table) { t.save.write(buf, "kvs_save_duration_seconds", l) })
+	family("kvs_save_errors_total", "counter", "Table saves that failed.")
+	each(func(l string, t *table) { sample("kvs_save_errors_total", l, float64(t.errors.Load())) })
+
+	return buf.Flush()
+}
+
+// write the cumulative buckets, sum, and count of the histogram
+func (h *histogram) write(w io.Writer, name, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var total uint64
+	for i := 0; i <= len(h.bounds); i++ {
+		if h.counts != nil {
+			total += h.counts[i]
+		}
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatFloat(h.bounds[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", name, labels, le, total)
+	}
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, total)
+}
+
+// quote a label value with the exposition format escapes
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
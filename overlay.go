@@ -0,0 +1,467 @@
+package kvs
+
+import (
+	"encoding/binary"
+	"os"
+
+	"github.com/zxdev/xxhash"
+)
+
+/*
+	OVERLAY stacks a read-only base table with a small mutable delta table
+	and a tombstone set so that a few changes can be applied to a very large
+	base without rebuilding it; Compact folds the layers into a new base.
+
+	delta  inserts and updates since the base
+	tomb   removals from the base
+	base   read-only *KEON or *KEVA
+
+	Lookup checks the delta, then the tombstones, then the base and the
+	delta never holds a key that is live in the base so the composite
+	Checksum matches the Checksum of the compacted table.
+
+	The layers are saved as separate regular kvs files so Info can
+	describe each of them; path, path.delta, and path.tomb
+
+	Note: the base is loaded into memory; the big endian on disk format
+	does not allow the slot array to be memory mapped directly.
+*/
+
+// OverlayKEON is a layered *KEON with a mutable delta and tombstones
+type OverlayKEON struct {
+	path  string // path to base file
+	dirty bool   // base was compacted
+	base  *KEON  // read-only base
+	delta *KEON  // inserts since base
+	tomb  *KEON  // removals from base
+}
+
+// OverlayKEVA is a layered *KEVA with a mutable delta and tombstones
+type OverlayKEVA struct {
+	path  string // path to base file
+	dirty bool   // base was compacted
+	base  *KEVA  // read-only base
+	delta *KEVA  // inserts and updates since base
+	tomb  *KEON  // removals from base
+}
+
+/*
+	OVERLAY package level functions
+		NewOverlayKEON, LoadOverlayKEON
+		NewOverlayKEVA, LoadOverlayKEVA
+
+*/
+
+// NewOverlayKEON is the *OverlayKEON constructor for a base *KEON with
+// a delta and tombstone capacity of n using the optional configuration.
+func NewOverlayKEON(base *KEON, n uint64, opt *Option) *OverlayKEON {
+	if base == nil || n == 0 {
+		return nil
+	}
+	return &OverlayKEON{path: base.path, base: base, delta: NewKEON(n, opt), tomb: NewKEON(n, opt)}
+}
+
+// LoadOverlayKEON loads the base *KEON at path along with the prior saved
+// path.delta and path.tomb layers when present, otherwise new layers with
+// capacity n are created; reports false when any layer fails validation.
+func LoadOverlayKEON(path string, n uint64, opt *Option) (*OverlayKEON, bool) {
+
+	base, ok := LoadKEON(path)
+	if !ok {
+		return nil, false
+	}
+
+	ov := NewOverlayKEON(base, n, opt)
+	if ov == nil {
+		return nil, false
+	}
+	if ov.delta, ok = loadLayerKEON(path+".delta", ov.delta); !ok {
+		return nil, false
+	}
+	if ov.tomb, ok = loadLayerKEON(path+".tomb", ov.tomb); !ok {
+		return nil, false
+	}
+
+	return ov, true
+}
+
+// NewOverlayKEVA is the *OverlayKEVA constructor for a base *KEVA with
+// a delta and tombstone capacity of n using the optional configuration.
+func NewOverlayKEVA(base *KEVA, n uint64, opt *Option) *OverlayKEVA {
+	if base == nil || n == 0 {
+		return nil
+	}
+	return &OverlayKEVA{path: base.path, base: base, delta: NewKEVA(n, opt), tomb: NewKEON(n, opt)}
+}
+
+// LoadOverlayKEVA loads the base *KEVA at path along with the prior saved
+// path.delta and path.tomb layers when present, otherwise new layers with
+// capacity n are created; reports false when any layer fails validation.
+func LoadOverlayKEVA(path string, n uint64, opt *Option) (*OverlayKEVA, bool) {
+
+	base, ok := LoadKEVA(path)
+	if !ok {
+		return nil, false
+	}
+
+	ov := NewOverlayKEVA(base, n, opt)
+	if ov == nil {
+		return nil, false
+	}
+	if ov.delta, ok = loadLayerKEVA(path+".delta", ov.delta); !ok {
+		return nil, false
+	}
+	if ov.tomb, ok = loadLayerKEON(path+".tomb", ov.tomb); !ok {
+		return nil, false
+	}
+
+	return ov, true
+}
+
+// loadLayerKEON loads the layer at path when present or uses the empty layer
+func loadLayerKEON(path string, empty *KEON) (*KEON, bool) {
+	if _, err := os.Stat(path); err != nil {
+		empty.path = path
+		return empty, true
+	}
+	return LoadKEON(path)
+}
+
+// loadLayerKEVA loads the layer at path when present or uses the empty layer
+func loadLayerKEVA(path string, empty *KEVA) (*KEVA, bool) {
+	if _, err := os.Stat(path); err != nil {
+		empty.path = path
+		return empty, true
+	}
+	return LoadKEVA(path)
+}
+
+/*
+	OVERLAY file i/o methods
+		ov.Write, ov.Save
+
+*/
+
+// Write *OverlayKEON layers to disk at path, path.delta, and path.tomb
+func (ov *OverlayKEON) Write(path string) error {
+	ov.path = path
+	ov.dirty = true
+	return ov.Save()
+}
+
+// Save *OverlayKEON layers to disk at prior Load/Write path; the base is
+// only rewritten after a Compact or when the path has changed
+func (ov *OverlayKEON) Save() error {
+
+	if len(ov.path) == 0 {
+		ov.path = "kvs.keon"
+	}
+
+	if ov.dirty {
+		if err := ov.base.Write(ov.path); err != nil {
+			return err
+		}
+		ov.dirty = false
+	}
+	if err := ov.delta.Write(ov.path + ".delta"); err != nil {
+		return err
+	}
+	return ov.tomb.Write(ov.path + ".tomb")
+}
+
+// Write *OverlayKEVA layers to disk at path, path.delta, and path.tomb
+func (ov *OverlayKEVA) Write(path string) error {
+	ov.path = path
+	ov.dirty = true
+	return ov.Save()
+}
+
+// Save *OverlayKEVA layers to disk at prior Load/Write path; the base is
+// only rewritten after a Compact or when the path has changed
+func (ov *OverlayKEVA) Save() error {
+
+	if len(ov.path) == 0 {
+		ov.path = "kvs.keva"
+	}
+
+	if ov.dirty {
+		if err := ov.base.Write(ov.path); err != nil {
+			return err
+		}
+		ov.dirty = false
+	}
+	if err := ov.delta.Write(ov.path + ".delta"); err != nil {
+		return err
+	}
+	return ov.tomb.Write(ov.path + ".tomb")
+}
+
+/*
+	OVERLAY utility and information methods
+		Checksum, Len, Cap, Compact
+
+*/
+
+// Checksum of the composite *OverlayKEON; tombstones are a subset of the base
+// and the delta is disjoint from the live base so the XOR of the layers is
+// the Checksum of the compacted table.
+func (ov *OverlayKEON) Checksum() uint64 {
+	return ov.base.Checksum() ^ ov.tomb.Checksum() ^ ov.delta.Checksum()
+}
+
+// Len is number of current entries.
+func (ov *OverlayKEON) Len() uint64 { return ov.base.count - ov.tomb.count + ov.delta.count }
+
+// Cap is max capacity of the *OverlayKEON delta.
+func (ov *OverlayKEON) Cap() uint64 { return ov.delta.max }
+
+// Compact folds the layers into a new base using the base format, or a larger
+// capacity when required, and clears the delta and tombstones; the base keeps
+// its path, write-ahead log, and metrics hook. Reports false and leaves the
+// layers unaltered on a shuffler failure.
+func (ov *OverlayKEON) Compact() bool {
+
+	var n = ov.Len()
+	if n < ov.base.max {
+		n = ov.base.max
+	}
+
+	var b [8]byte
+	var kn = NewKEON(n, ov.base.option())
+	insert := kn.RawInsert(false)
+	for _, layer := range []*KEON{ov.base, ov.delta} {
+		for _, k := range layer.key {
+			if k == 0 || layer == ov.base && ov.tomb.find(k) {
+				continue
+			}
+			binary.BigEndian.PutUint64(b[:], k)
+			if !insert(b[:]).Ok {
+				return false
+			}
+		}
+	}
+
+	// replace in place so that the existing closures remain valid
+	kn.path, kn.wal = ov.base.path, ov.base.wal
+	kn.name, kn.metrics = ov.base.name, ov.base.metrics
+	*ov.base = *kn
+	ov.delta.count, ov.tomb.count = 0, 0
+	ov.delta.sizer(false)
+	ov.tomb.sizer(false)
+	ov.dirty = true
+
+	return true
+}
+
+// Checksum of the composite *OverlayKEVA; tombstones are a subset of the base
+// and the delta is disjoint from the live base so the XOR of the layers is
+// the Checksum of the compacted table.
+func (ov *OverlayKEVA) Checksum() uint64 {
+	return ov.base.Checksum() ^ ov.tomb.Checksum() ^ ov.delta.Checksum()
+}
+
+// Len is number of current entries.
+func (ov *OverlayKEVA) Len() uint64 { return ov.base.count - ov.tomb.count + ov.delta.count }
+
+// Cap is max capacity of the *OverlayKEVA delta.
+func (ov *OverlayKEVA) Cap() uint64 { return ov.delta.max }
+
+// Compact folds the layers into a new base using the base format, or a larger
+// capacity when required, and clears the delta and tombstones; the base keeps
+// its path, write-ahead log, and metrics hook. Reports false and leaves the
+// layers unaltered on a shuffler failure.
+func (ov *OverlayKEVA) Compact() bool {
+
+	var n = ov.Len()
+	if n < ov.base.max {
+		n = ov.base.max
+	}
+
+	var b [8]byte
+	var kn = NewKEVA(n, ov.base.option())
+	insert := kn.RawInsert(false)
+	for _, layer := range []*KEVA{ov.base, ov.delta} {
+		for i, k := range layer.key {
+			if k == 0 || layer == ov.base && ov.tomb.find(k) {
+				continue
+			}
+			binary.BigEndian.PutUint64(b[:], k)
+			if !insert(b[:], layer.value[i]).Ok {
+				return false
+			}
+		}
+	}
+
+	// replace in place so that the existing closures remain valid
+	kn.path, kn.wal = ov.base.path, ov.base.wal
+	kn.name, kn.metrics = ov.base.name, ov.base.metrics
+	*ov.base = *kn
+	ov.delta.count, ov.tomb.count = 0, 0
+	ov.delta.sizer(false)
+	ov.tomb.sizer(false)
+	ov.dirty = true
+
+	return true
+}
+
+/*
+	OVERLAY primary management methods
+		Lookup, Remove, Insert
+
+*/
+
+// Lookup key in *OverlayKEON; delta, tombstones, base.
+func (ov *OverlayKEON) Lookup() func(key []byte) bool {
+	return func(key []byte) bool {
+		hash := xxhash.Sum(key)
+		return ov.delta.find(hash) || !ov.tomb.find(hash) && ov.base.find(hash)
+	}
+}
+
+// Remove key from *OverlayKEON.
+//
+//	Ok    key is valid; false with the tombstones at capacity
+//	Exist found in delta or base
+func (ov *OverlayKEON) Remove() func([]byte) struct{ Ok, Exist bool } {
+
+	var b [8]byte
+	remove := ov.delta.RawRemove()
+	tomb := ov.tomb.RawInsert(false)
+
+	return func(key []byte) (item struct{ Ok, Exist bool }) {
+
+		hash := xxhash.Sum(key)
+		binary.BigEndian.PutUint64(b[:], hash)
+		item = remove(b[:])
+		if !item.Exist && ov.base.find(hash) {
+			r := tomb(b[:])
+			item.Ok, item.Exist = r.Ok, r.Ok && !r.Exist
+		}
+
+		return
+	}
+}
+
+// Insert into *OverlayKEON.
+//
+//	Ok      flag on insert success
+//	Exist   flag when already present (or collision)
+//	NoSpace flag with delta or tombstones at capacity or shuffler failure
+func (ov *OverlayKEON) Insert(update bool) func([]byte) struct{ Ok, Exist, NoSpace bool } {
+
+	var b [8]byte
+	insert := ov.delta.RawInsert(update)
+	remove := ov.tomb.RawRemove()
+
+	return func(key []byte) (item struct{ Ok, Exist, NoSpace bool }) {
+
+		hash := xxhash.Sum(key)
+		binary.BigEndian.PutUint64(b[:], hash)
+		if ov.base.find(hash) {
+			if remove(b[:]).Exist { // restore a removed base key
+				item.Ok = true
+				return
+			}
+			item.Exist, item.Ok = true, update
+			return
+		}
+
+		return insert(b[:])
+	}
+}
+
+// Lookup key in *OverlayKEVA; delta, tombstones, base.
+func (ov *OverlayKEVA) Lookup() func(key []byte) (item struct {
+	Value uint64
+	Ok    bool
+}) {
+	return func(key []byte) (item struct {
+		Value uint64
+		Ok    bool
+	}) {
+		hash := xxhash.Sum(key)
+		if item.Value, item.Ok = ov.delta.find(hash); !item.Ok && !ov.tomb.find(hash) {
+			item.Value, item.Ok = ov.base.find(hash)
+		}
+		return
+	}
+}
+
+// Remove key from *OverlayKEVA.
+//
+//	Ok    key is valid; false with the tombstones at capacity
+//	Exist found in delta or base
+func (ov *OverlayKEVA) Remove() func([]byte) struct{ Ok, Exist bool } {
+
+	var b [8]byte
+	remove := ov.delta.RawRemove()
+	tomb := ov.tomb.RawInsert(false)
+
+	return func(key []byte) (item struct{ Ok, Exist bool }) {
+
+		hash := xxhash.Sum(key)
+		binary.BigEndian.PutUint64(b[:], hash)
+		item = remove(b[:])
+		if _, ok := ov.base.find(hash); ok && !ov.tomb.find(hash) {
+			r := tomb(b[:])
+			item.Ok, item.Exist = r.Ok, r.Ok && !r.Exist
+		}
+
+		return
+	}
+}
+
+// Insert into *OverlayKEVA; an update of a base key places a tombstone
+// on the base key and the new value in the delta.
+//
+//	Ok      flag on insert success
+//	Exist   flag when already present (or collision) or updated with update boolean
+//	NoSpace flag with delta or tombstones at capacity or shuffler failure
+func (ov *OverlayKEVA) Insert(update bool) func([]byte, uint64) struct{ Ok, Exist, NoSpace bool } {
+
+	var b [8]byte
+	insert := ov.delta.RawInsert(false)
+	remove := ov.delta.RawRemove()
+	tomb := ov.tomb.RawInsert(false)
+	untomb := ov.tomb.RawRemove()
+
+	return func(key []byte, value uint64) (item struct{ Ok, Exist, NoSpace bool }) {
+
+		hash := xxhash.Sum(key)
+		binary.BigEndian.PutUint64(b[:], hash)
+
+		if prior, ok := ov.delta.find(hash); ok {
+			item.Exist, item.Ok = true, update
+			if update {
+				// a failed update restores the prior value into the
+				// slot the remove freed
+				remove(b[:])
+				if r := insert(b[:], value); !r.Ok {
+					insert(b[:], prior)
+					item.Ok, item.NoSpace = false, r.NoSpace
+				}
+			}
+			return
+		}
+
+		var tombed bool
+		if _, ok := ov.base.find(hash); ok && !ov.tomb.find(hash) {
+			item.Exist, item.Ok = true, update
+			if !update {
+				return
+			}
+			if r := tomb(b[:]); !r.Ok {
+				item.Ok, item.NoSpace = false, r.NoSpace
+				return
+			}
+			tombed = true
+		}
+
+		r := insert(b[:], value)
+		if !r.Ok && tombed {
+			untomb(b[:]) // the base value remains
+		}
+		item.Ok, item.NoSpace = r.Ok, r.NoSpace
+		return
+	}
+}
@@ -0,0 +1,92 @@
+package kvs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+	PROGRESS is the optional callback of the context variants of the long
+	operations, LoadKEONContext, LoadKEVAContext, BuildContext, MergeContext,
+	WriteContext, and SaveContext; the context is checked every meterItems
+	items, or every chunk of a parallel load or save, so a cancellation or
+	deadline stops the operation promptly with the context error, and a
+	cancelled save never replaces the prior file
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	kn, err := st.BuildContext(ctx, opt, func(done, total uint64, remaining time.Duration) {
+		fmt.Printf("\r%d of %d %v", done, total, remaining)
+	})
+
+	progress is called at most every meterEvery and once on completion
+
+*/
+
+// Progress is called with the items done of the total and the estimated
+// time remaining of a long operation
+type Progress func(done, total uint64, remaining time.Duration)
+
+// meter intervals
+const (
+	meterItems = 1 << 16                // items between context checks
+	meterEvery = 100 * time.Millisecond // minimum interval between progress calls
+)
+
+// meter checks the context and reports the progress of an operation
+type meter struct {
+	ctx         context.Context
+	progress    Progress
+	total       uint64
+	start, last time.Time
+	mu          sync.Mutex // add
+	count       uint64     // add
+}
+
+// newMeter is the *meter constructor for total items
+func newMeter(ctx context.Context, total uint64, progress Progress) *meter {
+	var now = time.Now()
+	return &meter{ctx: ctx, progress: progress, total: total, start: now, last: now}
+}
+
+// step checks the context and reports progress every meterItems items
+func (m *meter) step(done uint64) error {
+	if done%meterItems != 0 {
+		return nil
+	}
+	return m.report(done, false)
+}
+
+// add n items done by a goroutine of a parallel operation; the context is
+// checked and the progress reported when due
+func (m *meter) add(n uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.count += n
+	return m.report(m.count, false)
+}
+
+// done checks the context and reports the completion of the operation
+func (m *meter) done(done uint64) error { return m.report(done, true) }
+
+// report the progress when due and return the context error
+func (m *meter) report(done uint64, final bool) error {
+	if err := m.ctx.Err(); err != nil {
+		return err
+	}
+	if m.progress == nil {
+		return nil
+	}
+	var now = time.Now()
+	if !final && now.Sub(m.last) < meterEvery {
+		return nil
+	}
+	m.last = now
+	var remaining time.Duration
+	if done > 0 && done < m.total {
+		remaining = time.Duration(float64(now.Sub(m.start)) * float64(m.total-done) / float64(done))
+	}
+	m.progress(done, m.total, remaining)
+	return nil
+}
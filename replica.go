@@ -0,0 +1,610 @@
+package kvs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+	REPLICATION ships the mutations of a primary table to replica tables
+	over a network connection; the primary assigns each journaled insert
+	or remove a monotonically increasing sequence number and groups the
+	mutations of an Update into a batch with the table checksum, and a
+	replica applies each batch in order and verifies the order independent
+	checksum after each batch.
+
+	A replica that is behind the primary backlog, that has a different
+	generation (the primary restarted or reloaded its table), or that
+	fails a checksum verification is sent a full snapshot instead.
+
+	primary
+	p, err := kvs.NewPrimary(h, 100000) // handle and backlog records
+	go kvs.ServeReplication(listener, map[string]*kvs.Primary{"block": p})
+	p.Update(func(keon *kvs.KEON, keva *kvs.KEVA) error { ... })
+
+	replica
+	r := kvs.NewReplica(h, "primary:7380", "block")
+	r.Start()
+	defer r.Close()
+
+	the checksum is the XOR of the keys so a keva value is carried by the
+	stream but is not covered by the verification; a mutation that is not
+	made through Primary.Update is not replicated, and the replica table
+	should only be modified by the replica.
+
+	stream, big endian
+
+	replica   "kvsr" | name size uint16 | name | generation | sequence
+	snapshot  'S' | generation | sequence | size | table file
+	batch     'B' | generation | first sequence | count uint32 | checksum | record ...
+	heartbeat 'H' | generation | sequence
+
+	record is the write-ahead log op|hash|value|crc record
+
+*/
+
+// replication stream framing
+const (
+	replMagic     = "kvsr"
+	replSnapshot  = 'S'
+	replBatch     = 'B'
+	replHeartbeat = 'H'
+	replBeat      = time.Second      // heartbeat interval
+	replTimeout   = 10 * time.Second // read timeout of a replica
+	replMaxBatch  = 1 << 24          // records in a batch
+)
+
+// ErrReplicaChecksum is a replica checksum verification failure
+var ErrReplicaChecksum = errors.New("kvs: replica checksum mismatch")
+
+// batch is a sequenced group of journal records and the table checksum
+// after the records were applied
+type batch struct {
+	first, checksum uint64
+	records         []byte // walRecord encoded
+}
+
+// journal attaches fn as the journal tap of the table and returns the
+// detach function
+func journal(keon *KEON, keva *KEVA, fn func(op byte, hash, value uint64)) func() {
+	var w **wal
+	if keon != nil {
+		w = &keon.wal
+	} else {
+		w = &keva.wal
+	}
+	if *w == nil {
+		*w = &wal{tap: fn}
+		return func() { *w = nil }
+	}
+	(*w).tap = fn
+	return func() { (*w).tap = nil }
+}
+
+/*
+	PRIMARY methods
+		NewPrimary, Update, Seq, Close, ServeReplication
+
+*/
+
+// Primary sequences the mutations of a *Handle table for replicas
+type Primary struct {
+	h          *Handle
+	mu         sync.Mutex    // sequence, batches, and snapshots
+	generation uint64        // table generation
+	seq        uint64        // last sequence number
+	checksum   uint64        // table checksum at seq
+	loaded     uint64        // handle table load of the generation
+	batches    []batch       // backlog
+	records    int           // records in the backlog
+	backlog    int           // backlog limit in records
+	notify     chan struct{} // closed on a new batch
+	done       chan struct{} // closed by Close
+}
+
+// NewPrimary is the *Primary constructor for the handle table that retains
+// up to backlog records for replicas that fall behind.
+func NewPrimary(h *Handle, backlog int) (*Primary, error) {
+	if backlog < 1 {
+		backlog = 1
+	}
+	var p = &Primary{h: h, backlog: backlog, generation: uint64(time.Now().UnixNano()),
+		notify: make(chan struct{}), done: make(chan struct{})}
+	err := h.Update(func(keon *KEON, keva *KEVA) error {
+		p.loaded = h.generation()
+		if keon != nil {
+			p.checksum = keon.Checksum()
+		} else {
+			p.checksum = keva.Checksum()
+		}
+		return nil
+	})
+	return p, err
+}
+
+// current adopts a new generation when the handle reloaded its table
+func (p *Primary) current() {
+	if loaded := p.h.generation(); loaded != p.loaded {
+		p.loaded = loaded
+		p.generation++
+		p.checksum = p.h.Info().Checksum
+		p.batches, p.records = nil, 0
+	}
+}
+
+// Update calls fn with the current table as Handle.Update does and ships
+// every successful insert and remove to the replicas as one batch; nothing
+// is shipped when fn returns an error since the handle discards the copy.
+func (p *Primary) Update(fn func(keon *KEON, keva *KEVA) error) error {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var b batch
+	err := p.h.Update(func(keon *KEON, keva *KEVA) error {
+		p.current()
+		var r [walRecord]byte
+		var checksum = p.checksum
+		defer journal(keon, keva, func(op byte, hash, value uint64) {
+			walEncode(&r, op, hash, value)
+			b.records = append(b.records, r[:]...)
+			p.checksum ^= hash
+		})()
+		if err := fn(keon, keva); err != nil {
+			p.checksum, b.records = checksum, nil
+			return err
+		}
+		return nil
+	})
+
+	if n := len(b.records) / walRecord; n > 0 {
+		b.first, b.checksum = p.seq+1, p.checksum
+		p.seq += uint64(n)
+		p.batches = append(p.batches, b)
+		for p.records += n; p.records > p.backlog && len(p.batches) > 1; p.batches = p.batches[1:] {
+			p.records -= len(p.batches[0].records) / walRecord
+		}
+		close(p.notify)
+		p.notify = make(chan struct{})
+	}
+	return err
+}
+
+// Seq is the sequence number of the last mutation.
+func (p *Primary) Seq() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.seq
+}
+
+// Close stops the replica streams.
+func (p *Primary) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+}
+
+// ServeReplication accepts replica connections on the listener for the
+// named primary tables until the listener is closed.
+func ServeReplication(ln net.Listener, tables map[string]*Primary) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			var hello [4 + 2]byte
+			conn.SetReadDeadline(time.Now().Add(replTimeout))
+			if _, err := io.ReadFull(conn, hello[:]); err != nil || string(hello[:4]) != replMagic {
+				return
+			}
+			var b = make([]byte, binary.BigEndian.Uint16(hello[4:])+16)
+			if _, err := io.ReadFull(conn, b); err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Time{})
+			if p, ok := tables[string(b[:len(b)-16])]; ok {
+				p.stream(conn, binary.BigEndian.Uint64(b[len(b)-16:]), binary.BigEndian.Uint64(b[len(b)-8:]))
+			}
+		}()
+	}
+}
+
+// stream the batches after seq, or a snapshot, and then each new batch to
+// the replica until the connection fails or the primary is closed
+func (p *Primary) stream(conn net.Conn, generation, seq uint64) error {
+
+	// a read returns when the replica disconnects
+	var gone = make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+
+	var w = bufio.NewWriterSize(conn, 64*1024)
+	var head [1 + 8*4]byte
+	var ticker = time.NewTicker(replBeat)
+	defer ticker.Stop()
+	for {
+
+		p.mu.Lock()
+		p.current()
+		var send []batch
+		var snapshot = generation != p.generation || seq > p.seq
+		if !snapshot && seq < p.seq {
+			snapshot = len(p.batches) == 0 || seq+1 < p.batches[0].first
+			for i := 0; !snapshot && i < len(p.batches); i++ {
+				if p.batches[i].first > seq {
+					send = p.batches[i:]
+					break
+				}
+			}
+		}
+		var kn *KEON
+		var kv *KEVA
+		if snapshot {
+			// the table checksum re-bases the stream when the table
+			// was modified other than by an Update of the primary
+			if kn, kv = p.h.snapshot(); kn != nil {
+				p.checksum = kn.Checksum()
+			} else {
+				p.checksum = kv.Checksum()
+			}
+			generation, seq = p.generation, p.seq
+		}
+		var notify = p.notify
+		p.mu.Unlock()
+
+		if snapshot {
+			if err := sendSnapshot(w, &head, generation, seq, kn, kv); err != nil {
+				return err
+			}
+		}
+
+		for _, b := range send {
+			head[0] = replBatch
+			binary.BigEndian.PutUint64(head[1:], generation)
+			binary.BigEndian.PutUint64(head[9:], b.first)
+			binary.BigEndian.PutUint32(head[17:], uint32(len(b.records)/walRecord))
+			binary.BigEndian.PutUint64(head[21:], b.checksum)
+			w.Write(head[:29])
+			w.Write(b.records)
+			seq = b.first + uint64(len(b.records)/walRecord) - 1
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if !snapshot && len(send) == 0 {
+			select {
+			case <-notify:
+			case <-ticker.C:
+				head[0] = replHeartbeat
+				binary.BigEndian.PutUint64(head[1:], generation)
+				binary.BigEndian.PutUint64(head[9:], seq)
+				w.Write(head[:17])
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			case <-gone:
+				return nil
+			case <-p.done:
+				return nil
+			}
+		}
+	}
+}
+
+// sendSnapshot writes the table to a temporary file, rather than saving the
+// primary table file, and sends the file as the snapshot at seq
+func sendSnapshot(w io.Writer, head *[1 + 8*4]byte, generation, seq uint64, kn *KEON, kv *KEVA) error {
+
+	f, err := os.CreateTemp("", "kvs-snapshot-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if kn != nil {
+		err = kn.Write(f.Name())
+	} else {
+		err = kv.Write(f.Name())
+	}
+	if err == nil {
+		f, err = os.Open(f.Name())
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	head[0] = replSnapshot
+	binary.BigEndian.PutUint64(head[1:], generation)
+	binary.BigEndian.PutUint64(head[9:], seq)
+	binary.BigEndian.PutUint64(head[17:], uint64(info.Size()))
+	w.Write(head[:25])
+	_, err = io.CopyN(w, f, info.Size())
+	return err
+}
+
+/*
+	REPLICA methods
+		NewReplica, Start, Seq, Close
+
+*/
+
+// Replica applies the mutation stream of a primary table to a *Handle
+// table; the handle file is replaced by each snapshot
+type Replica struct {
+	h                    *Handle
+	addr, name           string
+	mu                   sync.Mutex // generation, seq, checksum
+	generation, seq, sum uint64
+	stop                 chan struct{}
+	wg                   sync.WaitGroup
+
+	// OnSnapshot, OnBatch, and OnError are optional callbacks that are
+	// called after a snapshot or a batch is applied, or on a failure
+	OnSnapshot func(seq uint64)
+	OnBatch    func(seq uint64, records int)
+	OnError    func(err error)
+}
+
+// NewReplica is the *Replica constructor that replicates the named primary
+// table at the replication address to the handle table.
+func NewReplica(h *Handle, addr, name string) *Replica {
+	return &Replica{h: h, addr: addr, name: name}
+}
+
+// Start replicating in the background; the replica reconnects after a
+// failure and resumes from its sequence number.
+func (r *Replica) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		return // already started
+	}
+	r.stop = make(chan struct{})
+	r.wg.Add(1)
+	go func(stop chan struct{}) {
+		defer r.wg.Done()
+		var wait = 100 * time.Millisecond
+		for {
+			err := r.session(stop)
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err != nil && r.OnError != nil {
+				r.OnError(err)
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+			if wait *= 2; wait > 5*time.Second {
+				wait = 5 * time.Second
+			}
+		}
+	}(r.stop)
+}
+
+// Seq is the sequence number of the last applied mutation.
+func (r *Replica) Seq() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.seq
+}
+
+// Close stops replicating.
+func (r *Replica) Close() {
+	r.mu.Lock()
+	stop := r.stop
+	r.stop = nil
+	r.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		r.wg.Wait()
+	}
+}
+
+// session subscribes to the primary and applies the stream until failure
+func (r *Replica) session(stop chan struct{}) error {
+
+	conn, err := net.DialTimeout("tcp", r.addr, replTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var closed = make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-stop:
+			conn.Close()
+		case <-closed:
+		}
+	}()
+
+	r.mu.Lock()
+	var hello = append([]byte(replMagic), 0, 0)
+	binary.BigEndian.PutUint16(hello[4:], uint16(len(r.name)))
+	hello = append(hello, r.name...)
+	hello = binary.BigEndian.AppendUint64(hello, r.generation)
+	hello = binary.BigEndian.AppendUint64(hello, r.seq)
+	r.mu.Unlock()
+	if _, err = conn.Write(hello); err != nil {
+		return err
+	}
+
+	var buf = bufio.NewReaderSize(conn, 64*1024)
+	var head [8 * 4]byte
+	for {
+		conn.SetReadDeadline(time.Now().Add(replTimeout))
+		kind, err := buf.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case replHeartbeat:
+			if _, err = io.ReadFull(buf, head[:16]); err != nil {
+				return err
+			}
+
+		case replSnapshot:
+			if _, err = io.ReadFull(buf, head[:24]); err != nil {
+				return err
+			}
+			conn.SetReadDeadline(time.Time{})
+			if err = r.snapshot(buf, binary.BigEndian.Uint64(head[:]), binary.BigEndian.Uint64(head[8:]), int64(binary.BigEndian.Uint64(head[16:]))); err != nil {
+				return err
+			}
+
+		case replBatch:
+			if _, err = io.ReadFull(buf, head[:28]); err != nil {
+				return err
+			}
+			count := binary.BigEndian.Uint32(head[16:])
+			if count == 0 || count > replMaxBatch {
+				return errors.New("kvs: replica invalid batch")
+			}
+			var records = make([]byte, int(count)*walRecord)
+			if _, err = io.ReadFull(buf, records); err != nil {
+				return err
+			}
+			if err = r.batch(binary.BigEndian.Uint64(head[:]), binary.BigEndian.Uint64(head[8:]), binary.BigEndian.Uint64(head[20:]), records); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("kvs: replica invalid frame %q", kind)
+		}
+	}
+}
+
+// snapshot replaces the handle file and table with the snapshot
+func (r *Replica) snapshot(buf io.Reader, generation, seq uint64, size int64) error {
+
+	f, err := os.Create(r.h.path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err = io.CopyN(f, buf, size); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	r.h.reload.Lock()
+	err = os.Rename(f.Name(), r.h.path)
+	if err == nil {
+		err = r.h.replace()
+	}
+	r.h.reload.Unlock()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.generation, r.seq, r.sum = generation, seq, r.h.Info().Checksum
+	r.mu.Unlock()
+	if r.OnSnapshot != nil {
+		r.OnSnapshot(seq)
+	}
+	return nil
+}
+
+// batch applies the records to the handle table and verifies the checksum;
+// a failure resets the replica so the next session requests a snapshot
+func (r *Replica) batch(generation, first, checksum uint64, records []byte) error {
+
+	r.mu.Lock()
+	if generation != r.generation || first != r.seq+1 {
+		r.generation, r.seq = 0, 0
+		r.mu.Unlock()
+		return errors.New("kvs: replica out of sequence")
+	}
+
+	// an insert record is applied as a remove and insert for a keva
+	// so that the value of the last insert always wins
+	var sum = r.sum
+	err := r.h.Update(func(keon *KEON, keva *KEVA) error {
+		defer journal(keon, keva, func(op byte, hash, value uint64) { sum ^= hash })()
+		var remove func([]byte) struct{ Ok, Exist bool }
+		var insert func([]byte) struct{ Ok, Exist, NoSpace bool }
+		var insertValue func([]byte, uint64) struct{ Ok, Exist, NoSpace bool }
+		if keon != nil {
+			remove, insert = keon.RawRemove(), keon.RawInsert(false)
+		} else {
+			remove, insertValue = keva.RawRemove(), keva.RawInsert(false)
+		}
+
+		var b [8]byte
+		for n := 0; n < len(records); n += walRecord {
+			rec := records[n : n+walRecord]
+			if binary.BigEndian.Uint32(rec[17:]) != crc32.ChecksumIEEE(rec[:17]) {
+				return errors.New("kvs: replica record crc failure")
+			}
+			copy(b[:], rec[1:9])
+			switch {
+			case rec[0] == walRemove:
+				remove(b[:])
+			case keon != nil:
+				if !keon.find(binary.BigEndian.Uint64(b[:])) && !insert(b[:]).Ok {
+					return errors.New("kvs: replica no space")
+				}
+			default:
+				remove(b[:])
+				if !insertValue(b[:], binary.BigEndian.Uint64(rec[9:17])).Ok {
+					return errors.New("kvs: replica no space")
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil && sum != checksum {
+		err = ErrReplicaChecksum
+	}
+	if err != nil {
+		r.generation, r.seq = 0, 0
+		r.mu.Unlock()
+		return err
+	}
+
+	r.sum = sum
+	r.seq = first + uint64(len(records)/walRecord) - 1
+	seq := r.seq
+	r.mu.Unlock()
+	if r.OnBatch != nil {
+		r.OnBatch(seq, len(records)/walRecord)
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+package kvs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/zxdev/xxhash"
+)
+
+/*
+	SHARDED is a *KEON set split across shards so that a set is not
+	limited by one allocation or one file; a key is routed to its shard
+	by the upper 32 bits of the key hash with a multiply shift so any
+	shard count routes evenly, and each shard is saved as its own keon
+	file listed by a manifest so the shards load in parallel and can be
+	built, grown, and distributed individually.
+
+	rows within a shard are placed by calculate, the whole hash (and its
+	prime XORs) modulo the shard depth, so the routing bits take part in
+	the placement; the keys of a shard only share a range of the upper
+	bits while the lower bits stay uniform, so the rows of a shard still
+	fill evenly.
+
+	manifest, text
+
+	kvs-sharded 1 {shards}
+	{file} {checksum} {count}
+	...
+
+	the shard files are named {manifest}.{shard}.keon without the manifest
+	extension and are resolved relative to the manifest directory
+
+	s := kvs.NewSharded(2_000_000_000, 64, nil)
+	insert := s.Insert(false)
+	...
+	s.Write("big.kvss")
+	s, err := kvs.LoadSharded("big.kvss")
+
+*/
+
+// sharded manifest signature
+const shardedSignature = "kvs-sharded 1"
+
+// Sharded is a collection of *KEON shards with consistent key routing
+type Sharded struct {
+	path  string  // manifest path
+	shard []*KEON // shards
+}
+
+/*
+	sharded package level functions
+		NewSharded, LoadSharded
+
+*/
+
+// NewSharded is the *Sharded constructor for n keys split across the
+// shards that accepts optional configuration settings for every shard.
+func NewSharded(n uint64, shards int, opt *Option) *Sharded {
+
+	if n == 0 || shards < 1 {
+		return nil
+	}
+	if opt == nil {
+		opt = new(Option)
+	}
+
+	var s = &Sharded{shard: make([]*KEON, shards)}
+	var size = (n + uint64(shards) - 1) / uint64(shards)
+	for i := range s.shard {
+		var o = *opt // configure modifies the option
+		s.shard[i] = NewKEON(size, &o)
+	}
+	return s
+}
+
+// LoadSharded loads the manifest at path and each shard in parallel and
+// validates every shard against the manifest checksum.
+func LoadSharded(path string) (*Sharded, error) {
+
+	files, checksums, err := readManifest(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s = &Sharded{path: path, shard: make([]*KEON, len(files))}
+	var errs = make([]error, len(files))
+	parallel(len(files), func(i int) {
+		var ok bool
+		if s.shard[i], ok = LoadKEON(files[i]); !ok || s.shard[i].Checksum() != checksums[i] {
+			errs[i] = errors.New("kvs: invalid shard " + files[i])
+		}
+	})
+	if err = errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// readManifest reads the shard files and checksums of the manifest at path
+func readManifest(path string) (files []string, checksums []uint64, err error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var n int
+	var scanner = bufio.NewScanner(f)
+	if !scanner.Scan() || !strings.HasPrefix(scanner.Text(), shardedSignature+" ") {
+		return nil, nil, errors.New("kvs: invalid manifest " + path)
+	}
+	if _, err = fmt.Sscan(strings.TrimPrefix(scanner.Text(), shardedSignature), &n); err != nil || n < 1 {
+		return nil, nil, errors.New("kvs: invalid manifest " + path)
+	}
+
+	var dir = filepath.Dir(path)
+	for scanner.Scan() {
+		var file string
+		var checksum, count uint64
+		if _, err = fmt.Sscan(scanner.Text(), &file, &checksum, &count); err != nil {
+			return nil, nil, fmt.Errorf("kvs: invalid manifest %s: %w", path, err)
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		files, checksums = append(files, file), append(checksums, checksum)
+	}
+	if err = scanner.Err(); err == nil && len(files) != n {
+		err = errors.New("kvs: incomplete manifest " + path)
+	}
+	return files, checksums, err
+}
+
+// parallel calls fn for 0..n-1 on up to GOMAXPROCS goroutines
+func parallel(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	var next = make(chan int)
+	for w := 0; w < runtime.GOMAXPROCS(0) && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+/*
+	sharded file i/o methods
+		Write, Save
+
+*/
+
+// Write the shards and the manifest to path.
+func (s *Sharded) Write(path string) error {
+	s.path = path
+	return s.Save()
+}
+
+// Save the shards in parallel and then the manifest to the prior
+// Load/Write path; each file replaces the prior file once it is complete.
+func (s *Sharded) Save() error {
+
+	if len(s.path) == 0 {
+		s.path = "kvs.kvss"
+	}
+
+	var base = strings.TrimSuffix(s.path, filepath.Ext(s.path))
+	var errs = make([]error, len(s.shard))
+	parallel(len(s.shard), func(i int) {
+		errs[i] = s.shard[i].Write(fmt.Sprintf("%s.%04d.keon", base, i))
+	})
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	f, err := os.Create(s.path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	var buf = bufio.NewWriter(f)
+	fmt.Fprintln(buf, shardedSignature, len(s.shard))
+	for _, kn := range s.shard {
+		fmt.Fprintln(buf, filepath.Base(kn.path), kn.Checksum(), kn.count)
+	}
+	if err = buf.Flush(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
+
+/*
+	sharded information methods
+		Checksum, Len, Cap, Shards, Shard, Grow
+
+*/
+
+// route is the shard of the key hash; the shard keys share a range of the
+// upper 32 bits, which calculate also uses for the rows within the shard
+func (s *Sharded) route(hash uint64) int {
+	return int((hash >> 32) * uint64(len(s.shard)) >> 32)
+}
+
+// Checksum is the XOR of the shard checksums, and so of every key.
+func (s *Sharded) Checksum() (checksum uint64) {
+	for _, kn := range s.shard {
+		checksum ^= kn.Checksum()
+	}
+	return
+}
+
+// Len is number of current entries of every shard.
+func (s *Sharded) Len() (n uint64) {
+	for _, kn := range s.shard {
+		n += kn.count
+	}
+	return
+}
+
+// Cap is max capacity of every shard.
+func (s *Sharded) Cap() (n uint64) {
+	for _, kn := range s.shard {
+		n += kn.max
+	}
+	return
+}
+
+// Shards is the number of shards.
+func (s *Sharded) Shards() int { return len(s.shard) }
+
+// Shard is the *KEON of shard i; closures made before a Grow of the
+// shard must not be used after it.
+func (s *Sharded) Shard(i int) *KEON { return s.shard[i] }
+
+// Grow rebuilds shard i with capacity n and the same options; the shard is
+// unchanged when the keys do not fit or n is 0, and closures made before
+// the Grow must be made again.
+func (s *Sharded) Grow(i int, n uint64) error {
+
+	var kn = s.shard[i]
+	if n < kn.count {
+		return ErrNoSpace
+	}
+	var grown = NewKEON(n, kn.option())
+	if grown == nil {
+		return errors.New("kvs: invalid shard capacity")
+	}
+	var b [8]byte
+	var next = kn.Export()
+	var insert = grown.RawInsert(false)
+	for next(&b) {
+		if !insert(b[:]).Ok {
+			return ErrNoSpace
+		}
+	}
+	grown.path = kn.path
+	s.shard[i] = grown
+	return nil
+}
+
+/*
+	sharded primary management methods
+		Lookup, Remove, Insert
+
+*/
+
+// Lookup key in *Sharded.
+func (s *Sharded) Lookup() func(key []byte) bool { return s.lookup(xxhash.Sum) }
+func (s *Sharded) RawLookup() func(key []byte) bool {
+	return s.lookup(func(raw []byte) uint64 { return binary.BigEndian.Uint64(raw) })
+}
+
+func (s *Sharded) lookup(encoder func([]byte) uint64) func(key []byte) bool {
+
+	var b [8]byte
+	var lookup = make([]func([]byte) bool, len(s.shard))
+	for i, kn := range s.shard {
+		lookup[i] = kn.RawLookup()
+	}
+
+	return func(key []byte) bool {
+		hash := encoder(key)
+		binary.BigEndian.PutUint64(b[:], hash)
+		return lookup[s.route(hash)](b[:])
+	}
+}
+
+// Remove key from *Sharded.
+func (s *Sharded) Remove() func([]byte) struct{ Ok, Exist bool } { return s.remove(xxhash.Sum) }
+func (s *Sharded) RawRemove() func([]byte) struct{ Ok, Exist bool } {
+	return s.remove(func(raw []byte) uint64 { return binary.BigEndian.Uint64(raw) })
+}
+
+func (s *Sharded) remove(encoder func([]byte) uint64) func(key []byte) struct{ Ok, Exist bool } {
+
+	var b [8]byte
+	var remove = make([]func([]byte) struct{ Ok, Exist bool }, len(s.shard))
+	for i, kn := range s.shard {
+		remove[i] = kn.RawRemove()
+	}
+
+	return func(key []byte) struct{ Ok, Exist bool } {
+		hash := encoder(key)
+		binary.BigEndian.PutUint64(b[:], hash)
+		return remove[s.route(hash)](b[:])
+	}
+}
+
+// Insert into *Sharded.
+//
+//	boolean for updateable
+//
+//	Ok      flag on insert success
+//	Exist   flag when already present (or collision)
+//	NoSpace flag when the shard is at capacity or shuffler failure
+func (s *Sharded) Insert(update bool) func([]byte) struct{ Ok, Exist, NoSpace bool } {
+	return s.insert(update, xxhash.Sum)
+}
+func (s *Sharded) RawInsert(update bool) func([]byte) struct{ Ok, Exist, NoSpace bool } {
+	return s.insert(update, func(raw []byte) uint64 { return binary.BigEndian.Uint64(raw) })
+}
+
+func (s *Sharded) insert(update bool, encoder func([]byte) uint64) func([]byte) struct{ Ok, Exist, NoSpace bool } {
+
+	var b [8]byte
+	var insert = make([]func([]byte) struct{ Ok, Exist, NoSpace bool }, len(s.shard))
+	for i, kn := range s.shard {
+		insert[i] = kn.RawInsert(update)
+	}
+
+	return func(key []byte) struct{ Ok, Exist, NoSpace bool } {
+		hash := encoder(key)
+		binary.BigEndian.PutUint64(b[:], hash)
+		return insert[s.route(hash)](b[:])
+	}
+}
@@ -0,0 +1,290 @@
+package kvs
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/zxdev/xxhash"
+)
+
+/*
+	STAGE collects the key hashes (and values) of a stream of unknown
+	length so that a *KEON or *KEVA can be sized and built in a single
+	pass over the source; the staged hashes are held in memory up to
+	the limit and then spilled to a temporary file.
+
+	st := kvs.NewStageKEON(0)
+	defer st.Close()
+	for ... {
+		st.Add(key)
+	}
+	kn, err := st.Build(opt)
+
+*/
+
+// ErrNoSpace is the Build error when the table could not be arranged with
+// the configured density and shuffler settings
+var ErrNoSpace = errors.New("kvs: stage insert failure; no space")
+
+// stageLimit is the default number of staged items held in memory; 16MB
+const stageLimit = 1 << 21
+
+// stage is the spill file shared by StageKEON and StageKEVA
+type stage struct {
+	f     *os.File      // spill file
+	w     *bufio.Writer // spill writer
+	count uint64        // total items staged
+	err   error         // first spill error
+}
+
+// spill writes the records to the temporary spill file
+func (st *stage) spill(records []uint64) {
+	if st.err != nil {
+		return
+	}
+	if st.f == nil {
+		if st.f, st.err = os.CreateTemp("", "kvs-stage-*"); st.err != nil {
+			return
+		}
+		st.w = bufio.NewWriterSize(st.f, 1<<20)
+	}
+	var b [8]byte
+	for i := range records {
+		binary.BigEndian.PutUint64(b[:], records[i])
+		if _, st.err = st.w.Write(b[:]); st.err != nil {
+			return
+		}
+	}
+}
+
+// replay the spill file records of n uint64 to fn
+func (st *stage) replay(n int, fn func(r []uint64) bool) error {
+	if st.err != nil || st.f == nil {
+		return st.err
+	}
+	if err := st.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := st.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var buf = bufio.NewReaderSize(st.f, 1<<20)
+	var b = make([]byte, 8*n)
+	var r = make([]uint64, n)
+	for {
+		if _, err := io.ReadFull(buf, b); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		for i := range r {
+			r[i] = binary.BigEndian.Uint64(b[i*8:])
+		}
+		if !fn(r) {
+			return nil
+		}
+	}
+}
+
+// close and remove the spill file
+func (st *stage) close() {
+	if st.f != nil {
+		st.f.Close()
+		os.Remove(st.f.Name())
+		st.f = nil
+	}
+}
+
+/*
+	STAGE KEON
+
+*/
+
+// StageKEON stages keys for a single pass *KEON build
+type StageKEON struct {
+	stage
+	limit int      // memory limit
+	hash  []uint64 // staged hashes
+}
+
+// NewStageKEON is the *StageKEON constructor where limit is the number of
+// hashes held in memory before spilling to a temporary file; 0 default.
+func NewStageKEON(limit int) *StageKEON {
+	if limit <= 0 {
+		limit = stageLimit
+	}
+	return &StageKEON{limit: limit}
+}
+
+// Add key to the stage.
+func (st *StageKEON) Add(key []byte) { st.RawAdd(xxhash.Sum(key)) }
+
+// RawAdd the key hash to the stage.
+func (st *StageKEON) RawAdd(hash uint64) {
+	if len(st.hash) == st.limit {
+		st.spill(st.hash)
+		st.hash = st.hash[:0]
+	}
+	st.hash = append(st.hash, hash)
+	st.count++
+}
+
+// Len is the number of staged keys including duplicates.
+func (st *StageKEON) Len() uint64 { return st.count }
+
+// Build a *KEON sized to the staged keys and close the stage; a duplicate key
+// is only inserted once and the table is sized with duplicates as padding.
+// The partial table is returned with ErrNoSpace so that its Stats can be reported.
+func (st *StageKEON) Build(opt *Option) (*KEON, error) {
+	return st.BuildContext(context.Background(), opt, nil)
+}
+
+// BuildContext is Build that stops with the context error when the context
+// is done and reports the staged keys inserted to the optional progress.
+func (st *StageKEON) BuildContext(ctx context.Context, opt *Option, progress Progress) (*KEON, error) {
+
+	defer st.Close()
+
+	var kn = NewKEON(st.count, opt)
+	if kn == nil {
+		return nil, errors.New("kvs: empty stage")
+	}
+
+	var nospace bool
+	var stop error
+	var done uint64
+	var b [8]byte
+	var m = newMeter(ctx, st.count, progress)
+	var insert = kn.RawInsert(false)
+	add := func(r []uint64) bool {
+		binary.BigEndian.PutUint64(b[:], r[0])
+		nospace = insert(b[:]).NoSpace
+		done++
+		stop = m.step(done)
+		return !nospace && stop == nil
+	}
+
+	if err := st.replay(1, add); err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(st.hash) && !nospace && stop == nil; i++ {
+		add(st.hash[i : i+1])
+	}
+	switch {
+	case stop != nil:
+		return nil, stop
+	case nospace:
+		return kn, ErrNoSpace
+	}
+	if err := m.done(done); err != nil {
+		return nil, err
+	}
+
+	return kn, nil
+}
+
+// Close the stage and remove the spill file.
+func (st *StageKEON) Close() {
+	st.close()
+	st.hash = nil
+}
+
+/*
+	STAGE KEVA
+
+*/
+
+// StageKEVA stages keys and values for a single pass *KEVA build
+type StageKEVA struct {
+	stage
+	limit int      // memory limit
+	pair  []uint64 // staged hash, value pairs
+}
+
+// NewStageKEVA is the *StageKEVA constructor where limit is the number of
+// hash and value pairs held in memory before spilling to a temporary file; 0 default.
+func NewStageKEVA(limit int) *StageKEVA {
+	if limit <= 0 {
+		limit = stageLimit / 2
+	}
+	return &StageKEVA{limit: limit}
+}
+
+// Add key and value to the stage.
+func (st *StageKEVA) Add(key []byte, value uint64) { st.RawAdd(xxhash.Sum(key), value) }
+
+// RawAdd the key hash and value to the stage.
+func (st *StageKEVA) RawAdd(hash, value uint64) {
+	if len(st.pair) == st.limit*2 {
+		st.spill(st.pair)
+		st.pair = st.pair[:0]
+	}
+	st.pair = append(st.pair, hash, value)
+	st.count++
+}
+
+// Len is the number of staged keys including duplicates.
+func (st *StageKEVA) Len() uint64 { return st.count }
+
+// Build a *KEVA sized to the staged keys and close the stage; the first
+// value of a duplicate key is retained and the table is sized with
+// duplicates as padding.
+// The partial table is returned with ErrNoSpace so that its Stats can be reported.
+func (st *StageKEVA) Build(opt *Option) (*KEVA, error) {
+	return st.BuildContext(context.Background(), opt, nil)
+}
+
+// BuildContext is Build that stops with the context error when the context
+// is done and reports the staged keys inserted to the optional progress.
+func (st *StageKEVA) BuildContext(ctx context.Context, opt *Option, progress Progress) (*KEVA, error) {
+
+	defer st.Close()
+
+	var kn = NewKEVA(st.count, opt)
+	if kn == nil {
+		return nil, errors.New("kvs: empty stage")
+	}
+
+	var nospace bool
+	var stop error
+	var done uint64
+	var b [8]byte
+	var m = newMeter(ctx, st.count, progress)
+	var insert = kn.RawInsert(false)
+	add := func(r []uint64) bool {
+		binary.BigEndian.PutUint64(b[:], r[0])
+		nospace = insert(b[:], r[1]).NoSpace
+		done++
+		stop = m.step(done)
+		return !nospace && stop == nil
+	}
+
+	if err := st.replay(2, add); err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(st.pair) && !nospace && stop == nil; i += 2 {
+		add(st.pair[i : i+2])
+	}
+	switch {
+	case stop != nil:
+		return nil, stop
+	case nospace:
+		return kn, ErrNoSpace
+	}
+	if err := m.done(done); err != nil {
+		return nil, err
+	}
+
+	return kn, nil
+}
+
+// Close the stage and remove the spill file.
+func (st *StageKEVA) Close() {
+	st.close()
+	st.pair = nil
+}
@@ -0,0 +1,56 @@
+package kvs
+
+import "math/bits"
+
+/*
+	STATS are the cumulative insert shuffle statistics of a table since
+	it was created or loaded, so a build can be profiled and the Density,
+	Width, Shuffler, and Tracker options tuned from data rather than by
+	trial and error; the statistics are held in memory and not saved
+
+	kn.Insert(false) ...
+	s := kn.Stats()
+	s.Direct      new keys placed in an empty slot of one of the key rows
+	s.Displaced   new keys placed by the shuffler displacing other keys
+	s.Swaps[i]    displaced placements that took 2^i to 2^(i+1)-1 swaps
+	s.Aborts      shuffle tracks abandoned by the cyclic movement tracker
+	s.Full        NoSpace inserts with the table at capacity
+	s.Exhausted   NoSpace inserts with the shuffler cycles exhausted
+	s.Onset       fill ratio of the first displaced placement; 0 never
+
+	a rising Aborts per Displaced suggests a larger Tracker and Exhausted
+	failures well before Full suggest more Density padding or Shuffler
+
+*/
+
+// stats is the Stats insert shuffle statistics
+type stats = struct {
+	Direct, Displaced uint64     // placements
+	Swaps             [16]uint64 // displaced placements by log2 swaps
+	Aborts            uint64     // cyclic tracker aborts
+	Full, Exhausted   uint64     // NoSpace causes
+	Onset             float64    // fill ratio of the first displacement
+}
+
+// Stats is the cumulative insert shuffle statistics of *KEON.
+func (kn *KEON) Stats() stats { return kn.stats }
+
+// Stats is the cumulative insert shuffle statistics of *KEVA.
+func (kn *KEVA) Stats() stats { return kn.stats }
+
+// displaced records a displaced placement that took swaps
+func displaced(s *stats, swaps uint64) {
+	s.Displaced++
+	i := bits.Len64(swaps) - 1
+	if i >= len(s.Swaps) {
+		i = len(s.Swaps) - 1
+	}
+	s.Swaps[i]++
+}
+
+// onset records the fill ratio of the first displaced placement
+func onset(s *stats, count, max uint64) {
+	if s.Onset == 0 {
+		s.Onset = float64(count) / float64(max)
+	}
+}
@@ -0,0 +1,142 @@
+package kvs
+
+import (
+	"time"
+)
+
+/*
+	TUNE searches the Option space for a key set, or a representative
+	sample of a key set, and recommends the Option that best meets the
+	goal; each candidate is built and measured and reported as a Trial.
+
+	result := kvs.AutoTune(keys, kvs.Goal{Target: kvs.TuneMemory})
+	if result.Ok {
+		opt := result.Option // recommended
+		kn := result.KEON    // built with the recommended option
+	}
+
+*/
+
+// AutoTune goal targets
+const (
+	TuneMemory = iota // smallest memory
+	TuneBuild         // fastest build
+	TuneLookup        // fastest lookup
+	TuneFill          // fastest build that meets the Fill ratio
+)
+
+// Goal is the AutoTune objective
+type Goal struct {
+	Target int     // TuneMemory, TuneBuild, TuneLookup, TuneFill
+	Fill   float64 // TuneFill target fill ratio; eg. 0.999
+}
+
+// Trial is an AutoTune candidate measurement
+type Trial struct {
+	Option        Option  // candidate settings
+	Ok            bool    // built without a NoSpace failure
+	Fill          float64 // count / slots
+	Memory        uint64  // key slice bytes
+	Build, Lookup float64 // keys per second
+}
+
+// tune search space; a failed build is retried with the escalated shuffler
+var (
+	tuneDensity  = []uint64{1000, 1, 2, 5, 10, 25, 50}
+	tuneWidth    = []uint64{3, 4, 5}
+	tuneShuffler = []uint64{500, 1500}
+)
+
+// AutoTune builds a *KEON for each candidate Option using keys and recommends
+// the Option that best meets the goal; the table built with the recommended
+// Option is returned, and since the Option is relative to the key count it
+// applies to a *KEVA and to the full key set when keys is a sample.
+func AutoTune(keys [][]byte, goal Goal) (result struct {
+	Ok     bool    // a candidate was built
+	Option Option  // recommended settings
+	KEON   *KEON   // table built with the recommended settings
+	Trials []Trial // candidate measurements in search order
+}) {
+
+	if len(keys) == 0 {
+		return
+	}
+
+	var best = -1 // index of the recommended trial
+	for _, width := range tuneWidth {
+		for _, density := range tuneDensity {
+			for _, shuffler := range tuneShuffler {
+
+				trial, kn := tune(keys, Option{Density: density, Width: width, Shuffler: shuffler, Tracker: 17 * int(width)})
+				result.Trials = append(result.Trials, trial)
+				if !trial.Ok {
+					continue // escalate shuffler
+				}
+
+				if best < 0 || goal.better(&trial, &result.Trials[best]) {
+					best = len(result.Trials) - 1
+					result.KEON = kn
+				}
+				break
+			}
+		}
+	}
+
+	if best >= 0 {
+		result.Ok = true
+		result.Option = result.Trials[best].Option
+	}
+
+	return
+}
+
+// tune builds and measures a candidate
+func tune(keys [][]byte, opt Option) (trial Trial, kn *KEON) {
+
+	trial.Option = opt
+	kn = NewKEON(uint64(len(keys)), &opt) // configure alters opt
+
+	var insert = kn.Insert(false)
+	var t0 = time.Now()
+	for i := range keys {
+		if insert(keys[i]).NoSpace {
+			return trial, nil
+		}
+	}
+	trial.Build = float64(len(keys)) / time.Since(t0).Seconds()
+
+	var lookup = kn.Lookup()
+	t0 = time.Now()
+	for i := range keys {
+		lookup(keys[i])
+	}
+	trial.Lookup = float64(len(keys)) / time.Since(t0).Seconds()
+
+	trial.Ok = true
+	trial.Fill = float64(kn.count) / float64(len(kn.key))
+	trial.Memory = uint64(len(kn.key)) * 8
+
+	return trial, kn
+}
+
+// better reports when trial a meets the goal better than trial b
+func (g Goal) better(a, b *Trial) bool {
+	switch g.Target {
+	case TuneBuild:
+		return a.Build > b.Build
+	case TuneLookup:
+		return a.Lookup > b.Lookup
+	case TuneFill:
+		switch {
+		case a.Fill >= g.Fill && b.Fill >= g.Fill:
+			return a.Build > b.Build
+		case a.Fill >= g.Fill || b.Fill >= g.Fill:
+			return a.Fill >= g.Fill
+		}
+		return a.Fill > b.Fill // closest to the target
+	}
+	if a.Memory == b.Memory {
+		return a.Build > b.Build
+	}
+	return a.Memory < b.Memory
+}
@@ -0,0 +1,118 @@
+package kvs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// Verify performs a deep integrity check of the *KEON or *KEVA file at path
+// by streaming the file rather than loading it, so a damaged file is reported
+// on rather than rejected; Info only validates that the header is readable.
+//
+//	Size      file size matches the header format
+//	Checksum  recomputed checksum matches the header
+//	Count     non-zero slots match the header count
+//	Misplaced stored hashes that are not in one of their calculate rows
+//	Gaps      buckets with an empty slot before an occupied slot; a removal
+//	          compacts the bucket and an insert fills the first empty slot
+//	          so a gap indicates corruption
+//	Orphans   keva slots with a zero key and a non-zero value
+func Verify(path string) (report struct {
+	Ok, Header                   bool   // all checks passed, header valid
+	Signature                    uint64 // 0xff01 keon, 0xff02 keva
+	Size, Expect                 int64  // file size and expected file size
+	Checksum, Computed           uint64 // header and recomputed checksum
+	Count, Slots                 uint64 // header count and non-zero slots
+	Misplaced, Gaps, Orphans     uint64 // slot problems
+	Depth, Width, Max, Timestamp uint64 // header format
+}) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	if stat, err := f.Stat(); err == nil {
+		report.Size = stat.Size()
+	}
+
+	var header [80]byte
+	var buf = bufio.NewReader(f)
+	if _, err = io.ReadFull(buf, header[:]); err != nil {
+		return
+	}
+	report.Signature = binary.BigEndian.Uint64(header[:8])
+	report.Checksum = binary.BigEndian.Uint64(header[8:16])
+	report.Timestamp = binary.BigEndian.Uint64(header[16:24])
+	report.Count = binary.BigEndian.Uint64(header[24:32])
+	report.Max = binary.BigEndian.Uint64(header[32:40])
+	report.Depth = binary.BigEndian.Uint64(header[40:48])
+	report.Width = binary.BigEndian.Uint64(header[48:56])
+
+	var record int64 // bytes per slot
+	switch report.Signature {
+	case 0xff01:
+		record = 8
+	case 0xff02:
+		record = 16
+	}
+
+	// a valid header describes a format that can be verified
+	report.Header = record > 0 && report.Depth > 0 && report.Width > 0 && report.Count <= report.Max &&
+		report.Depth*report.Width >= report.Max
+	if !report.Header {
+		return
+	}
+	report.Expect = 80 + int64(report.Depth*report.Width)*record
+
+	// calculate the rows for each stored hash using the header format
+	var kn = &KEON{hloc: 3, depth: report.Depth, width: report.Width}
+	var idx [4]uint64
+	var b [16]byte
+	var k, v uint64
+	var empty bool
+	for row := uint64(0); row < report.Depth; row++ {
+		empty = false
+		for j := uint64(0); j < report.Width; j++ {
+			if _, err = io.ReadFull(buf, b[:record]); err != nil {
+				row = report.Depth // truncated; reported by size
+				break
+			}
+			k = binary.BigEndian.Uint64(b[:8])
+			if record == 16 {
+				v = binary.BigEndian.Uint64(b[8:])
+			}
+
+			if k == 0 {
+				if v != 0 {
+					report.Orphans++
+				}
+				empty = true
+				continue
+			}
+
+			if empty {
+				report.Gaps++
+				empty = false
+			}
+			report.Slots++
+			report.Computed ^= k
+
+			idx[kn.hloc] = k
+			kn.calculate(&idx)
+			if idx[0] != row*report.Width && idx[1] != row*report.Width && idx[2] != row*report.Width {
+				report.Misplaced++
+			}
+		}
+	}
+
+	report.Ok = report.Size == report.Expect &&
+		report.Checksum == report.Computed &&
+		report.Count == report.Slots &&
+		report.Misplaced+report.Gaps+report.Orphans == 0
+
+	return
+}
@@ -0,0 +1,306 @@
+package kvs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+/*
+	WAL is an optional write-ahead log attached to a table so that each
+	successful insert or remove between saves is durable; the log holds
+	compact fixed size records that are replayed on top of the snapshot.
+
+	op|hash|value|crc
+	 1    8     8   4  bytes, big endian, crc32 ieee of op|hash|value
+
+	Replay applies the last operation per key so the log is idempotent
+	and a torn record at the tail of the log (a crash during an append)
+	is discarded. Checkpoint saves a new snapshot and truncates the log.
+
+	kn, err := kvs.OpenKEVAWithWAL(path)
+	insert := kn.Insert(false)
+	...
+	kn.Checkpoint()
+	kn.CloseWAL()
+*/
+
+// wal record operations
+const (
+	walInsert byte = iota + 1
+	walRemove
+)
+
+// wal record size
+const walRecord = 21
+
+// wal is the write-ahead log file and an optional journal tap that
+// observes each record; a tap without a file only observes
+type wal struct {
+	f   *os.File                          // append only log
+	b   [walRecord]byte                   // record buffer
+	err error                             // first append error
+	tap func(op byte, hash, value uint64) // journal observer
+}
+
+// openWAL opens or creates the append only log at path
+func openWAL(path string) (*wal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &wal{f: f}, nil
+}
+
+// append a record to the log; the first error is retained and
+// reported by the next Checkpoint, SyncWAL, or CloseWAL
+func (w *wal) append(op byte, hash, value uint64) {
+	if w.tap != nil {
+		w.tap(op, hash, value)
+	}
+	if w.f == nil || w.err != nil {
+		return
+	}
+	walEncode(&w.b, op, hash, value)
+	_, w.err = w.f.Write(w.b[:])
+}
+
+// walEncode encodes the record with its crc
+func walEncode(b *[walRecord]byte, op byte, hash, value uint64) {
+	b[0] = op
+	binary.BigEndian.PutUint64(b[1:9], hash)
+	binary.BigEndian.PutUint64(b[9:17], value)
+	binary.BigEndian.PutUint32(b[17:], crc32.ChecksumIEEE(b[:17]))
+}
+
+// sync the log to disk
+func (w *wal) sync() error {
+	if w.f == nil || w.err != nil {
+		return w.err
+	}
+	return w.f.Sync()
+}
+
+// truncate the log after a snapshot
+func (w *wal) truncate() error {
+	if w.f == nil || w.err != nil {
+		return w.err
+	}
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+// close the log
+func (w *wal) close() error {
+	err := w.sync()
+	if w.f == nil {
+		return err
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// replayWAL reads the log at path and applies each valid record in order;
+// a missing log is empty and a torn or corrupt tail is truncated
+func replayWAL(path string, apply func(op byte, hash, value uint64) error) error {
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var b [walRecord]byte
+	var offset int64
+	var buf = bufio.NewReader(f)
+	for {
+		if _, err = io.ReadFull(buf, b[:]); err != nil {
+			break // io.EOF or io.UnexpectedEOF
+		}
+		if binary.BigEndian.Uint32(b[17:]) != crc32.ChecksumIEEE(b[:17]) {
+			break
+		}
+		if err = apply(b[0], binary.BigEndian.Uint64(b[1:9]), binary.BigEndian.Uint64(b[9:17])); err != nil {
+			return err
+		}
+		offset += walRecord
+	}
+
+	// discard anything after the last valid record
+	return f.Truncate(offset)
+}
+
+/*
+	WAL package level functions
+		OpenKEONWithWAL, OpenKEVAWithWAL
+
+*/
+
+// OpenKEONWithWAL loads the *KEON snapshot at path, replays the path.wal
+// log on top of the snapshot, and attaches the log to the *KEON.
+func OpenKEONWithWAL(path string) (*KEON, error) {
+
+	kn, ok := LoadKEON(path)
+	if !ok {
+		return nil, errors.New("kvs: invalid keon " + path)
+	}
+
+	var b [8]byte
+	insert := kn.RawInsert(true)
+	remove := kn.RawRemove()
+	err := replayWAL(path+".wal", func(op byte, hash, value uint64) error {
+		binary.BigEndian.PutUint64(b[:], hash)
+		switch op {
+		case walInsert:
+			if !kn.find(hash) && !insert(b[:]).Ok {
+				return errors.New("kvs: wal replay no space")
+			}
+		case walRemove:
+			remove(b[:])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return kn, kn.AttachWAL()
+}
+
+// OpenKEVAWithWAL loads the *KEVA snapshot at path, replays the path.wal
+// log on top of the snapshot, and attaches the log to the *KEVA.
+func OpenKEVAWithWAL(path string) (*KEVA, error) {
+
+	kn, ok := LoadKEVA(path)
+	if !ok {
+		return nil, errors.New("kvs: invalid keva " + path)
+	}
+
+	// an insert record is applied as a remove and insert
+	// so that the value of the last insert always wins
+	var b [8]byte
+	insert := kn.RawInsert(false)
+	remove := kn.RawRemove()
+	err := replayWAL(path+".wal", func(op byte, hash, value uint64) error {
+		binary.BigEndian.PutUint64(b[:], hash)
+		switch op {
+		case walInsert:
+			remove(b[:])
+			if !insert(b[:], value).Ok {
+				return errors.New("kvs: wal replay no space")
+			}
+		case walRemove:
+			remove(b[:])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return kn, kn.AttachWAL()
+}
+
+/*
+	WAL methods
+		AttachWAL, Checkpoint, SyncWAL, CloseWAL
+
+*/
+
+// AttachWAL attaches the write-ahead log at the Load/Write path.wal
+// so every successful insert or remove is appended to the log.
+func (kn *KEON) AttachWAL() (err error) {
+	if len(kn.path) == 0 {
+		kn.path = "kvs.keon"
+	}
+	if kn.wal == nil {
+		kn.wal, err = openWAL(kn.path + ".wal")
+	}
+	return
+}
+
+// Checkpoint saves a *KEON snapshot and truncates the write-ahead log.
+func (kn *KEON) Checkpoint() error {
+	if kn.wal == nil {
+		return kn.Save()
+	}
+	if err := kn.wal.sync(); err != nil {
+		return err
+	}
+	if err := kn.Save(); err != nil {
+		return err
+	}
+	return kn.wal.truncate()
+}
+
+// SyncWAL flushes the write-ahead log to disk.
+func (kn *KEON) SyncWAL() error {
+	if kn.wal == nil {
+		return nil
+	}
+	return kn.wal.sync()
+}
+
+// CloseWAL syncs and detaches the write-ahead log.
+func (kn *KEON) CloseWAL() error {
+	if kn.wal == nil {
+		return nil
+	}
+	err := kn.wal.close()
+	kn.wal = nil
+	return err
+}
+
+// AttachWAL attaches the write-ahead log at the Load/Write path.wal
+// so every successful insert or remove is appended to the log.
+func (kn *KEVA) AttachWAL() (err error) {
+	if len(kn.path) == 0 {
+		kn.path = "kvs.keva"
+	}
+	if kn.wal == nil {
+		kn.wal, err = openWAL(kn.path + ".wal")
+	}
+	return
+}
+
+// Checkpoint saves a *KEVA snapshot and truncates the write-ahead log.
+func (kn *KEVA) Checkpoint() error {
+	if kn.wal == nil {
+		return kn.Save()
+	}
+	if err := kn.wal.sync(); err != nil {
+		return err
+	}
+	if err := kn.Save(); err != nil {
+		return err
+	}
+	return kn.wal.truncate()
+}
+
+// SyncWAL flushes the write-ahead log to disk.
+func (kn *KEVA) SyncWAL() error {
+	if kn.wal == nil {
+		return nil
+	}
+	return kn.wal.sync()
+}
+
+// CloseWAL syncs and detaches the write-ahead log.
+func (kn *KEVA) CloseWAL() error {
+	if kn.wal == nil {
+		return nil
+	}
+	err := kn.wal.close()
+	kn.wal = nil
+	return err
+}